@@ -0,0 +1,123 @@
+package power
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/store"
+
+	"github.com/adamhassel/errors"
+)
+
+// BackfillChunk is a period backfilled, and how many prices were stored for it
+type BackfillChunk struct {
+	From, To time.Time
+	Prices   int
+	// SpotOnly is how many of the prices were only stored as spot prices, since
+	// there's no snapshot of the tariffs from the time to calculate full prices with
+	SpotOnly int
+	// Skipped is true if the chunk was already in the store
+	Skipped bool
+}
+
+// Backfill fetches prices in the area a from `from` until `to`, `days` days at
+// a time, and saves them in the store, along with the EUR exchange rate of each
+// day. Chunks with all spot prices already in the store are skipped, so an
+// interrupted backfill can be resumed by running it again. progress, if not
+// nil, is called after each chunk.
+//
+// Full prices are calculated with the tariffs known at the time of each day,
+// and only stored for days with a snapshot of them. See tariffsAt. If c is nil,
+// the global configuration is used.
+func (s *PriceService) Backfill(ctx context.Context, from, to time.Time, days int, a energidataservice.Area, c interfaces.Configurator, progress func(BackfillChunk)) error {
+	if s.store == nil {
+		return errors.New("backfilling needs a store")
+	}
+	if c == nil {
+		c = config.GetConf()
+	}
+	if days < 1 {
+		days = 1
+	}
+	from = from.In(entities.Location)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, entities.Location)
+	for start := from; start.Before(to); {
+		end := start.AddDate(0, 0, days)
+		if end.After(to) {
+			end = to
+		}
+		chunk := BackfillChunk{From: start, To: end}
+		stored, err := s.backfilled(a, c, start, end)
+		if err != nil {
+			return err
+		}
+		if stored {
+			chunk.Skipped = true
+		} else {
+			if err := s.saveRates(ctx, start, end); err != nil {
+				return fmt.Errorf("backfilling exchange rates %s - %s: %w", start.Format("2006-01-02"), end.Format("2006-01-02"), err)
+			}
+			var ps []entities.FullPrice
+			if end.Before(s.clock.Now().Truncate(time.Hour)) {
+				ps, chunk.SpotOnly, err = s.historicalPrices(ctx, start, end, a, c, true)
+			} else {
+				ps, err = s.PricesInArea(ctx, start, end, a, c, true)
+			}
+			if err != nil {
+				return fmt.Errorf("backfilling %s - %s: %w", start.Format("2006-01-02"), end.Format("2006-01-02"), err)
+			}
+			chunk.Prices = len(ps)
+		}
+		if progress != nil {
+			progress(chunk)
+		}
+		start = end
+	}
+	return nil
+}
+
+// Backfill calls Backfill on the default PriceService
+func Backfill(ctx context.Context, from, to time.Time, days int, a energidataservice.Area, c interfaces.Configurator, progress func(BackfillChunk)) error {
+	return Default.Backfill(ctx, from, to, days, a, c, progress)
+}
+
+// backfilled returns true if the store has everything a backfill of from - to in
+// the area a can store: the final spot prices, and the full prices, unless
+// there's no snapshot of the tariffs of the metering point in c from the time.
+func (s *PriceService) backfilled(a energidataservice.Area, c interfaces.Configurator, from, to time.Time) (bool, error) {
+	if ok, err := s.spotStored(a, from, to); !ok || err != nil {
+		return false, err
+	}
+	if _, ok := s.storedPrices(a, from, to); ok {
+		return true, nil
+	}
+	// snapshots are only ever saved of the tariffs at the time, so if there's
+	// none from before the end, there won't be
+	_, err := s.store.Tariffs(c.MID(), to)
+	if errors.Is(err, store.ErrNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// spotStored returns true if the store has final spot prices for all of from - to in the area a
+func (s *PriceService) spotStored(a energidataservice.Area, from, to time.Time) (bool, error) {
+	ps, err := s.store.SpotPrices(string(a), from, to)
+	if err != nil || len(ps) == 0 {
+		return false, err
+	}
+	covered := from
+	for _, p := range ps {
+		start := time.Time(p.HourUTC)
+		if start.After(covered) || p.SpotPriceDKK == nil || p.DKKEstimated {
+			return false, nil
+		}
+		covered = start.Add(p.Duration())
+	}
+	return !covered.Before(to), nil
+}
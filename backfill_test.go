@@ -0,0 +1,160 @@
+package power
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill_Resume(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "power.db"))
+	require.NoError(t, err)
+	defer st.Close()
+	s := NewPriceService(nil, nil, nil)
+	s.UseStore(st)
+
+	from := time.Date(2022, 3, 26, 0, 0, 0, 0, entities.Location)
+	to := from.AddDate(0, 0, 2)
+	var spot []entities.Elspotprice
+	// hourly prices in UTC, across the switch to daylight saving time
+	for h := from.UTC(); h.Before(to); h = h.Add(time.Hour) {
+		dkk := 1000.0
+		spot = append(spot, entities.Elspotprice{HourUTC: entities.PTime(h), SpotPriceDKK: &dkk})
+	}
+	a := energidataservice.AreaDKEast
+	require.NoError(t, st.SaveSpotPrices(string(a), spot))
+
+	var chunks []BackfillChunk
+	require.NoError(t, s.Backfill(context.Background(), from.Add(12*time.Hour), to, 1, a, nil, func(c BackfillChunk) {
+		chunks = append(chunks, c)
+	}))
+	require.Len(t, chunks, 2)
+	for _, c := range chunks {
+		assert.True(t, c.Skipped)
+	}
+	assert.Equal(t, from, chunks[0].From)
+	// the second day is only 23 hours long
+	assert.Equal(t, 23*time.Hour, chunks[1].To.Sub(chunks[1].From))
+}
+
+func TestSpotStored(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "power.db"))
+	require.NoError(t, err)
+	defer st.Close()
+	s := NewPriceService(nil, nil, nil)
+	s.UseStore(st)
+
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	dkk := 1000.0
+	spot := []entities.Elspotprice{
+		{HourUTC: entities.PTime(start), SpotPriceDKK: &dkk},
+		{HourUTC: entities.PTime(start.Add(time.Hour)), SpotPriceDKK: &dkk, DKKEstimated: true},
+		{HourUTC: entities.PTime(start.Add(2 * time.Hour)), SpotPriceDKK: &dkk},
+		{HourUTC: entities.PTime(start.Add(4 * time.Hour)), SpotPriceDKK: &dkk, Resolution: 15 * time.Minute},
+	}
+	a := energidataservice.AreaDKWest
+	require.NoError(t, st.SaveSpotPrices(string(a), spot))
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     bool
+	}{
+		{name: "stored", from: start, to: start.Add(time.Hour), want: true},
+		{name: "estimated", from: start, to: start.Add(2 * time.Hour)},
+		{name: "hole", from: start.Add(2 * time.Hour), to: start.Add(4 * time.Hour)},
+		{name: "quarter", from: start.Add(4 * time.Hour), to: start.Add(4*time.Hour + 15*time.Minute), want: true},
+		{name: "nothing", from: start.Add(5 * time.Hour), to: start.Add(6 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.spotStored(a, tt.from, tt.to)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBackfill_TariffSnapshots(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "power.db"))
+	require.NoError(t, err)
+	defer st.Close()
+	up := fakeupstream.New(t)
+	s := fakeService(up, time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location))
+	s.UseStore(st)
+	ctx := context.Background()
+	c := fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}
+
+	// a snapshot from Saturday, but none from before
+	saturday := fakeService(up, time.Date(2025, 3, 8, 12, 0, 0, 0, entities.Location))
+	saturday.UseStore(st)
+	require.NoError(t, saturday.LoadTariffs(ctx, c))
+
+	from := time.Date(2025, 3, 7, 0, 0, 0, 0, entities.Location)
+	a := energidataservice.AreaDKWest
+	var chunks []BackfillChunk
+	require.NoError(t, s.Backfill(ctx, from, from.AddDate(0, 0, 2), 1, a, c, func(c BackfillChunk) {
+		chunks = append(chunks, c)
+	}))
+	require.Len(t, chunks, 2)
+	assert.Equal(t, BackfillChunk{From: from, To: from.AddDate(0, 0, 1), Prices: 24, SpotOnly: 24}, chunks[0])
+	assert.Equal(t, BackfillChunk{From: from.AddDate(0, 0, 1), To: from.AddDate(0, 0, 2), Prices: 24}, chunks[1])
+
+	// Friday's full prices aren't stored with today's tariffs
+	ps, err := st.Prices(string(a), from, from.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Empty(t, ps)
+	ps, err = st.Prices(string(a), from.AddDate(0, 0, 1), from.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Len(t, ps, 24)
+
+	// with the exchange rates of the time, months before the clock of the
+	// service. Saturday has Friday's rate.
+	for _, d := range []time.Time{from, from.AddDate(0, 0, 1)} {
+		rate, err := st.Rate("EUR", d)
+		require.NoError(t, err)
+		assert.InDelta(t, 7.4602, rate, 1e-9)
+	}
+
+	// but there's nothing more to store for Friday. Saturday's DKK prices
+	// are estimated, and may be improved on.
+	chunks = nil
+	require.NoError(t, s.Backfill(ctx, from, from.AddDate(0, 0, 2), 1, a, c, func(c BackfillChunk) {
+		chunks = append(chunks, c)
+	}))
+	require.Len(t, chunks, 2)
+	assert.True(t, chunks[0].Skipped)
+	assert.False(t, chunks[1].Skipped)
+}
+
+func TestStoredRates(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "power.db"))
+	require.NoError(t, err)
+	defer st.Close()
+	s := NewPriceService(nil, nil, nil)
+	s.UseRates(fixedRate(7.5))
+	d := time.Date(2025, 3, 7, 0, 0, 0, 0, entities.Location)
+	require.NoError(t, st.SaveRate("EUR", d, 7.4602))
+
+	// without a store, the rates come from the provider
+	rate, err := storedRates{s}.Rate(context.Background(), "EUR", d)
+	require.NoError(t, err)
+	assert.Equal(t, 7.5, rate)
+
+	// with one, rates stored are preferred
+	s.UseStore(st)
+	rate, err = storedRates{s}.Rate(context.Background(), "EUR", d.Add(12*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 7.4602, rate)
+	rate, err = storedRates{s}.Rate(context.Background(), "EUR", d.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 7.5, rate)
+}
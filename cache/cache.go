@@ -0,0 +1,67 @@
+// Package cache is a concurrency safe cache, which loads each missing value only
+// once, no matter how many ask for it at the same time.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache holds a value per key
+type Cache[K comparable, V any] struct {
+	mu      sync.RWMutex
+	entries map[K]V
+	flight  singleflight.Group
+}
+
+// New returns an empty cache
+func New[K comparable, V any]() *Cache[K, V] {
+	return &Cache[K, V]{entries: make(map[K]V)}
+}
+
+// Get returns the value cached for k, and whether there is one
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.entries[k]
+	return v, ok
+}
+
+// Set caches v for k
+func (c *Cache[K, V]) Set(k K, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[k] = v
+}
+
+// loadTimeout limits loads, since they aren't cancelled with the context of
+// whoever started them
+const loadTimeout = 5 * time.Minute
+
+// Load calls load, and caches the value it returns for k, unless it fails. If
+// a load of k is already in progress, Load waits for that instead, and returns
+// its result. Others may be waiting for the load too, so it isn't cancelled
+// with ctx, but gets the values of ctx and a timeout of its own. Load returns
+// ctx.Err() if ctx is done before the load.
+func (c *Cache[K, V]) Load(ctx context.Context, k K, load func(ctx context.Context) (V, error)) (V, error) {
+	ch := c.flight.DoChan(fmt.Sprint(k), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		v, err := load(ctx)
+		if err == nil {
+			c.Set(k, v)
+		}
+		return v, err
+	})
+	select {
+	case r := <-ch:
+		return r.Val.(V), r.Err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/internal/ctxtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Load(t *testing.T) {
+	c := New[string, int]()
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	const n = 50
+	var calls int32
+	release := make(chan struct{})
+	load := func(context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+	// the load is released once all n are waiting for it
+	var waiting sync.WaitGroup
+	waiting.Add(n)
+	var wg sync.WaitGroup
+	results := make([]int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := c.Load(ctxtest.Waiting(context.Background(), waiting.Done), "a", load)
+			assert.NoError(t, err)
+			results[i] = v
+		}(i)
+	}
+	waiting.Wait()
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, r := range results {
+		assert.Equal(t, 42, r)
+	}
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 42, v)
+}
+
+func TestCache_LoadError(t *testing.T) {
+	c := New[string, int]()
+	c.Set("a", 1)
+	_, err := c.Load(context.Background(), "a", func(context.Context) (int, error) { return 2, errors.New("failed") })
+	assert.Error(t, err)
+	// failed loads don't replace what's cached
+	v, _ := c.Get("a")
+	assert.Equal(t, 1, v)
+}
+
+func TestCache_LoadCancelled(t *testing.T) {
+	c := New[string, int]()
+	started, release := make(chan struct{}), make(chan struct{})
+	load := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.Load(ctx, "a", load)
+		first <- err
+	}()
+	<-started
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	// the one who started the load giving up doesn't cancel the load
+	close(release)
+	assert.Eventually(t, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == 42
+	}, time.Second, time.Millisecond)
+}
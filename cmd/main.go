@@ -1,67 +1,325 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/adamhassel/power"
+	"github.com/adamhassel/power/entities"
 	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/format"
+	"github.com/adamhassel/power/influx"
+	"github.com/adamhassel/power/logging"
+	"github.com/adamhassel/power/mqtt"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/store"
+	"github.com/adamhassel/power/transport"
 )
 
-var confFile string
+var confFile, area, deadline, below, outFormat, logLevel, logFormat string
 var noOfHours uint
-var pretty, simple bool
+var pretty, simple, writeInflux, mqttDaemon bool
+var window, onTime, minBlock, maxGap time.Duration
 
 func init() {
 	flag.UintVar(&noOfHours, "h", 12, "Number of hours to get price data for.")
 	flag.StringVar(&confFile, "c", "power.conf", "location of configuration file.")
 	flag.BoolVar(&pretty, "p", false, "pretty-print (indent) JSON output.")
 	flag.BoolVar(&simple, "s", false, "simple data output, only period and total price.")
+	flag.StringVar(&outFormat, "f", "json", "output format: json, csv, tsv or influx (line protocol). csv, tsv and influx only work when listing prices.")
+	flag.BoolVar(&writeInflux, "influx", false, "write prices to the InfluxDB configured in the configuration file, instead of printing them.")
+	flag.BoolVar(&mqttDaemon, "mqtt", false, "run as a daemon, publishing prices to the MQTT broker configured in the configuration file.")
+	flag.StringVar(&area, "a", "", "price area, DK1 or DK2. Overrides the area in the configuration file.")
+	flag.DurationVar(&window, "w", 0, "find the cheapest contiguous window of this length (e.g. 3h or 90m), instead of listing prices.")
+	flag.DurationVar(&onTime, "n", 0, "pick the cheapest slots to be on in for this long in total, not necessarily contiguous, instead of listing prices.")
+	flag.DurationVar(&minBlock, "minblock", 0, "for -n, the shortest time to stay on once switched on.")
+	flag.DurationVar(&maxGap, "maxgap", 0, "for -n, the longest time to stay off between being on. Default is no limit.")
+	flag.StringVar(&below, "below", "", "for -n, always be on when the price (inc. VAT) is below this.")
+	flag.StringVar(&deadline, "d", "", "deadline for -w and -n, as RFC 3339 or HH:MM. Default is as late as prices are available.")
+	flag.StringVar(&logLevel, "loglevel", "info", "log level: debug, info, warn or error. Logs go to stderr.")
+	flag.StringVar(&logFormat, "logformat", "text", "log format: text or json.")
 }
 
 func main() {
 	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the command given by the flags. Errors are returned rather than
+// fatal, so the store is closed on the way out.
+func run() error {
+	if err := logging.Setup(logLevel, logFormat); err != nil {
+		return err
+	}
+	// stop fetching when interrupted
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 	var conf config.Config
 	if err := conf.Load(confFile); err != nil {
-		log.Fatalf("error reading conf: %s", err)
+		return fmt.Errorf("error reading conf: %w", err)
 	}
 	if conf.MID() == "" || conf.Token() == "" {
-		log.Fatal("MID or Token invalid")
+		return errors.New("MID or Token invalid")
 	}
+	transport.Default = transport.New(conf.HTTP().Options())
+	power.UseUpstream(conf.Upstream())
 
-	prices, err := power.Prices(time.Now(), time.Now().Add(time.Duration(noOfHours)*time.Hour), conf, true)
-	if err != nil {
-		log.Fatal(err)
+	// check the backfill arguments before anything is fetched
+	var bf backfillArgs
+	if flag.Arg(0) == "backfill" {
+		var err error
+		if bf, err = parseBackfillArgs(conf, flag.Args()[1:]); err != nil {
+			return err
+		}
 	}
-	var data interface{}
-	if simple {
-		type Simple struct {
-			Period string `json:"period"`
-			Price  string `json:"price"`
+
+	if conf.Store() != "" {
+		s, err := store.Open(conf.Store())
+		if err != nil {
+			return fmt.Errorf("error opening store: %w", err)
 		}
-		o := make([]Simple, len(prices))
-		for i, p := range prices {
-			o[i].Period = fmt.Sprintf("%s - %s", p.ValidFrom.Format("15:04"), p.ValidTo.Format("15:04"))
-			o[i].Price = fmt.Sprintf("%0.2f kr.", p.TotalIncVAT)
+		defer s.Close()
+		power.UseStore(s)
+	}
+
+	if writeInflux {
+		outFormat = "influx"
+	}
+	switch outFormat {
+	case "json":
+	case "csv", "tsv", "influx":
+		if simple || window > 0 || onTime > 0 {
+			return fmt.Errorf("%s output only works when listing full prices", outFormat)
 		}
-		data = o
+	default:
+		return fmt.Errorf("unknown output format '%s'", outFormat)
+	}
+
+	var a energidataservice.Area
+	var err error
+	if area != "" {
+		a, err = energidataservice.ParseArea(area)
 	} else {
-		data = prices
+		a, err = power.Area(ctx, conf)
+	}
+	if err != nil {
+		return err
+	}
+
+	if flag.Arg(0) == "backfill" {
+		return backfill(ctx, a, conf, bf)
+	}
+
+	if mqttDaemon {
+		return publishMQTT(ctx, a, conf)
+	}
+
+	var data interface{}
+	switch {
+	case window > 0:
+		data, err = cheapestWindow(ctx, a, conf)
+	case onTime > 0:
+		data, err = cheapestSlots(ctx, a, conf)
+	default:
+		data, err = prices(ctx, a, conf)
+	}
+	if err != nil {
+		return err
 	}
 
 	var output []byte
-	if pretty {
+	switch {
+	case outFormat == "influx":
+		output = format.Influx(data.([]entities.FullPrice), map[string]string{"area": string(a), "mid": conf.MID()})
+	case outFormat == "csv":
+		err = format.CSV(os.Stdout, data.([]entities.FullPrice))
+	case outFormat == "tsv":
+		err = format.TSV(os.Stdout, data.([]entities.FullPrice))
+	case pretty:
 		output, err = json.MarshalIndent(data, "", "  ")
-	} else {
+	default:
 		output, err = json.Marshal(data)
 	}
 
 	if err != nil {
-		log.Fatalf("error marshalling result: %s", err)
+		return fmt.Errorf("error marshalling result: %w", err)
+	}
+
+	if writeInflux {
+		c, err := influx.New(conf.Influx())
+		if err != nil {
+			return err
+		}
+		if err := c.Write(output); err != nil {
+			return fmt.Errorf("error writing to influxdb: %w", err)
+		}
+		return nil
 	}
 
 	fmt.Print(string(output))
+	return nil
+}
+
+func prices(ctx context.Context, a energidataservice.Area, conf config.Config) (interface{}, error) {
+	prices, err := power.PricesInArea(ctx, time.Now(), time.Now().Add(time.Duration(noOfHours)*time.Hour), a, conf, true)
+	if err != nil {
+		return nil, err
+	}
+	if !simple {
+		return prices, nil
+	}
+	type Simple struct {
+		Period string `json:"period"`
+		Price  string `json:"price"`
+	}
+	o := make([]Simple, len(prices))
+	for i, p := range prices {
+		o[i].Period = fmt.Sprintf("%s - %s", p.ValidFrom.Format("15:04"), p.ValidTo.Format("15:04"))
+		o[i].Price = fmt.Sprintf("%0.2f kr.", p.TotalIncVAT)
+	}
+	return o, nil
+}
+
+// publishMQTT publishes prices to MQTT until ctx is done
+func publishMQTT(ctx context.Context, a energidataservice.Area, conf config.Config) error {
+	p, err := mqtt.New(conf.MQTT(), string(a))
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	p.Run(ctx, func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+		return power.PricesInArea(ctx, from, to, a, conf, true)
+	})
+	return nil
+}
+
+// backfillArgs are the arguments of the backfill subcommand
+type backfillArgs struct {
+	from, to time.Time
+	days     int
+}
+
+// parseBackfillArgs parses the arguments in args of the backfill subcommand,
+// which needs a store configured in conf
+func parseBackfillArgs(conf config.Config, args []string) (backfillArgs, error) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromStr := fs.String("from", "", "first date to backfill, as YYYY-MM-DD. Required.")
+	toStr := fs.String("to", "", "date to backfill until (not including), as YYYY-MM-DD. Default is today.")
+	days := fs.Int("chunk", 7, "number of days to fetch at a time.")
+	fs.Parse(args)
+	if conf.Store() == "" {
+		return backfillArgs{}, errors.New("backfill needs a store, set 'store' in the configuration file")
+	}
+	rv := backfillArgs{days: *days}
+	var err error
+	if rv.from, err = time.ParseInLocation("2006-01-02", *fromStr, entities.Location); err != nil {
+		return backfillArgs{}, fmt.Errorf("error parsing -from: %w", err)
+	}
+	now := time.Now().In(entities.Location)
+	rv.to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, entities.Location)
+	if *toStr != "" {
+		if rv.to, err = time.ParseInLocation("2006-01-02", *toStr, entities.Location); err != nil {
+			return backfillArgs{}, fmt.Errorf("error parsing -to: %w", err)
+		}
+	}
+	if !rv.from.Before(rv.to) {
+		return backfillArgs{}, fmt.Errorf("-from %s isn't before -to %s", rv.from.Format("2006-01-02"), rv.to.Format("2006-01-02"))
+	}
+	return rv, nil
+}
+
+// backfill runs the backfill subcommand in the area a, with the arguments in args
+func backfill(ctx context.Context, a energidataservice.Area, conf config.Config, args backfillArgs) error {
+	total := args.to.Sub(args.from)
+	return power.Backfill(ctx, args.from, args.to, args.days, a, conf, func(c power.BackfillChunk) {
+		status := fmt.Sprintf("%d prices", c.Prices)
+		if c.SpotOnly > 0 {
+			status += fmt.Sprintf(", %d only as spot prices, with no tariffs known from then", c.SpotOnly)
+		}
+		if c.Skipped {
+			status = "already stored"
+		}
+		fmt.Fprintf(os.Stderr, "%s - %s: %s (%.0f%%)\n", c.From.Format("2006-01-02"), c.To.Format("2006-01-02"), status,
+			100*float64(c.To.Sub(args.from))/float64(total))
+	})
+}
+
+func parseDeadline() (time.Time, error) {
+	if deadline == "" {
+		return time.Time{}, nil
+	}
+	return entities.ParseTime(deadline, time.Now())
+}
+
+func cheapestWindow(ctx context.Context, a energidataservice.Area, conf config.Config) (interface{}, error) {
+	d, err := parseDeadline()
+	if err != nil {
+		return nil, err
+	}
+	w, err := power.CheapestWindow(ctx, window, time.Now(), d, a, conf, true)
+	if err != nil {
+		return nil, err
+	}
+	if !simple {
+		return w, nil
+	}
+	type Simple struct {
+		Period  string `json:"period"`
+		Average string `json:"average"`
+	}
+	return Simple{
+		Period:  fmt.Sprintf("%s - %s", w.From.Format("15:04"), w.To.Format("15:04")),
+		Average: fmt.Sprintf("%0.2f kr.", w.Average),
+	}, nil
+}
+
+func cheapestSlots(ctx context.Context, a energidataservice.Area, conf config.Config) (interface{}, error) {
+	o := power.SlotOptions{
+		Duration: onTime,
+		From:     time.Now(),
+		MinBlock: minBlock,
+		MaxGap:   maxGap,
+	}
+	var err error
+	if o.Deadline, err = parseDeadline(); err != nil {
+		return nil, err
+	}
+	if below != "" {
+		b, err := strconv.ParseFloat(below, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s as a number", below)
+		}
+		o.AlwaysOnBelow = &b
+	}
+	s, err := power.CheapestSlots(ctx, o, a, conf, true)
+	if err != nil {
+		return nil, err
+	}
+	if !simple {
+		return s, nil
+	}
+	type Simple struct {
+		At    string `json:"at"`
+		State string `json:"state"`
+	}
+	out := make([]Simple, len(s.Switches))
+	for i, sw := range s.Switches {
+		out[i].At = sw.At.Format("2006-01-02 15:04")
+		out[i].State = "off"
+		if sw.On {
+			out[i].State = "on"
+		}
+	}
+	return out, nil
 }
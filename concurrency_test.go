@@ -0,0 +1,64 @@
+package power
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/internal/ctxtest"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockedSpot counts the calls for spot prices, and provides them from next
+// once release is closed
+type blockedSpot struct {
+	next    interfaces.SpotPriceProvider
+	calls   *int32
+	release <-chan struct{}
+}
+
+func (b blockedSpot) SpotPrices(ctx context.Context, from, to time.Time, area string) ([]entities.Elspotprice, error) {
+	atomic.AddInt32(b.calls, 1)
+	<-b.release
+	return b.next.SpotPrices(ctx, from, to, area)
+}
+
+func TestPricesInArea_Concurrent(t *testing.T) {
+	up := fakeupstream.New(t)
+	now := time.Date(2025, 10, 6, 10, 0, 0, 0, entities.Location)
+	const n = 20
+	var calls int32
+	release := make(chan struct{})
+	tariffs := func(c interfaces.Configurator) interfaces.TariffProvider { return up.Tariffs(c) }
+	s := NewPriceService(blockedSpot{next: up.SpotPrices(), calls: &calls, release: release}, tariffs, ClockFunc(func() time.Time { return now }))
+	c := fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}
+
+	// the fetch is released once all n are waiting for prices
+	var waiting sync.WaitGroup
+	waiting.Add(n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := ctxtest.Waiting(context.Background(), waiting.Done)
+			ps, err := s.PricesInArea(ctx, now, now.Add(2*time.Hour), energidataservice.AreaDKWest, c, false)
+			assert.NoError(t, err)
+			assert.Len(t, ps, 8)
+		}()
+	}
+	waiting.Wait()
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, 1, up.Requests("/DayAheadPrices"))
+	// tariffs are fetched once too
+	assert.Equal(t, 1, up.Requests("/token"))
+	assert.Equal(t, 1, up.Requests("/meteringpoints/meteringpoint/getcharges"))
+}
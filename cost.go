@@ -0,0 +1,232 @@
+package power
+
+import (
+	"time"
+
+	"github.com/adamhassel/power/entities"
+)
+
+// vatRate is the Danish VAT (moms) rate
+const vatRate = 0.25
+
+// Cost is the cost of power consumed in a period, split into its components. All amounts are in DKK.
+type Cost struct {
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	KWh         float64        `json:"kwh"`
+	Spot        float64        `json:"spot_ex_vat"`
+	Taxes       entities.Taxes `json:"taxes"`
+	Fixed       entities.Taxes `json:"fixed_charges"`
+	Total       float64        `json:"total_ex_vat"`
+	VAT         float64        `json:"vat"`
+	TotalIncVAT float64        `json:"total_inc_vat"`
+}
+
+// CostReport is the cost of consumption, summarized per hour, day and month in Danish time.
+// Readings that no price could be found for are listed in Unpriced, and are
+// not included in the summaries. Fixed charges are pro-rated for each period,
+// so a month always carries the full subscription, even if only part of it has
+// been consumed yet.
+type CostReport struct {
+	Hourly   []Cost                `json:"hourly"`
+	Daily    []Cost                `json:"daily"`
+	Monthly  []Cost                `json:"monthly"`
+	Unpriced entities.Consumptions `json:"unpriced,omitempty"`
+}
+
+// At returns the price valid at t, if fp contains one.
+func (fp FullPrices) At(t time.Time) (entities.FullPrice, bool) {
+	for _, p := range fp.Contents {
+		if !t.Before(p.ValidFrom) && t.Before(p.ValidTo) {
+			return p, true
+		}
+	}
+	return entities.FullPrice{}, false
+}
+
+// Over returns the price of the period from - to: the average of the prices in
+// fp it overlaps, weighted by how much of the period each of them covers, so an
+// hour of quarter hour prices costs their average. It returns false if fp
+// doesn't cover all of the period.
+func (fp FullPrices) Over(from, to time.Time) (entities.FullPrice, bool) {
+	period := to.Sub(from)
+	if period <= 0 {
+		return fp.At(from)
+	}
+	rv := entities.FullPrice{ValidFrom: from, ValidTo: to}
+	var covered time.Duration
+	for _, p := range fp.Contents {
+		start, end := p.ValidFrom, p.ValidTo
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !start.Before(end) {
+			continue
+		}
+		covered += end.Sub(start)
+		w := float64(end.Sub(start)) / float64(period)
+		taxes := make(entities.Taxes, len(p.Taxes))
+		for i, t := range p.Taxes {
+			taxes[i] = entities.Tax{Name: t.Name, Amount: t.Amount * w}
+		}
+		rv.Taxes = addTaxes(rv.Taxes, taxes)
+		rv.RawPrice += p.RawPrice * w
+		rv.TaxesSubTotal += p.TaxesSubTotal * w
+		rv.Total += p.Total * w
+		rv.TotalIncVAT += p.TotalIncVAT * w
+		rv.Estimated = rv.Estimated || p.Estimated
+	}
+	if covered < period {
+		return entities.FullPrice{}, false
+	}
+	return rv, true
+}
+
+// Costs calculates the cost of the readings in cs, using the prices in fp and
+// adding the fixed charges in ch. A reading spanning several price periods is
+// priced with their average. See Over. Readings are expected to be sorted by
+// time, which is how eloverblik returns them.
+func Costs(cs entities.Consumptions, fp FullPrices, ch entities.Charges) CostReport {
+	var r CostReport
+	for _, c := range cs {
+		p, ok := fp.Over(c.ValidFrom, c.ValidTo)
+		if !ok {
+			r.Unpriced = append(r.Unpriced, c)
+			continue
+		}
+		cost := costOf(c, p)
+		from := c.ValidFrom.In(entities.Location)
+		hour := from.Truncate(time.Hour)
+		day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+		month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+		r.Hourly = addCost(r.Hourly, cost, hour, hour.Add(time.Hour))
+		r.Daily = addCost(r.Daily, cost, day, day.AddDate(0, 0, 1))
+		r.Monthly = addCost(r.Monthly, cost, month, month.AddDate(0, 1, 0))
+	}
+	for _, list := range [][]Cost{r.Hourly, r.Daily, r.Monthly} {
+		for i := range list {
+			list[i] = list[i].withFixed(ch.For(list[i].From, list[i].To))
+		}
+	}
+	return r
+}
+
+// EstimateMonth estimates the bill for the month containing t. The variable
+// cost of the days in r in that month is extrapolated to the full month, and
+// the fixed charges in ch for the month are added. Months are in Danish time.
+func EstimateMonth(r CostReport, ch entities.Charges, t time.Time) Cost {
+	t = t.In(entities.Location)
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, entities.Location)
+	to := from.AddDate(0, 1, 0)
+	var days int
+	var variable Cost
+	for _, d := range r.Daily {
+		if d.From.Before(from) || !d.From.Before(to) {
+			continue
+		}
+		days++
+		variable = variable.Add(d.variable())
+	}
+	rv := Cost{From: from, To: to}
+	if days > 0 {
+		rv = rv.Add(variable.scale(float64(to.AddDate(0, 0, -1).Day()) / float64(days)))
+	}
+	return rv.withFixed(ch.For(from, to))
+}
+
+// withFixed returns c with fixed charges f added to it
+func (c Cost) withFixed(f entities.Taxes) Cost {
+	rv := c
+	rv.Fixed = f
+	rv.Total = c.Spot + c.Taxes.Total() + f.Total()
+	rv.VAT = rv.Total * vatRate
+	rv.TotalIncVAT = rv.Total + rv.VAT
+	return rv
+}
+
+// variable returns c without fixed charges
+func (c Cost) variable() Cost {
+	return c.withFixed(nil)
+}
+
+// scale returns c with all amounts multiplied by f
+func (c Cost) scale(f float64) Cost {
+	rv := c
+	rv.KWh *= f
+	rv.Spot *= f
+	rv.Total *= f
+	rv.VAT *= f
+	rv.TotalIncVAT *= f
+	rv.Taxes = make(entities.Taxes, len(c.Taxes))
+	for i, t := range c.Taxes {
+		rv.Taxes[i] = entities.Tax{Name: t.Name, Amount: t.Amount * f}
+	}
+	return rv
+}
+
+// costOf returns the cost of the single reading c at price p
+func costOf(c entities.Consumption, p entities.FullPrice) Cost {
+	taxes := make(entities.Taxes, len(p.Taxes))
+	for i, t := range p.Taxes {
+		taxes[i] = entities.Tax{Name: t.Name, Amount: t.Amount * c.KWh}
+	}
+	rv := Cost{
+		From:  c.ValidFrom,
+		To:    c.ValidTo,
+		KWh:   c.KWh,
+		Spot:  p.RawPrice * c.KWh,
+		Taxes: taxes,
+	}
+	rv.Total = rv.Spot + taxes.Total()
+	rv.VAT = rv.Total * vatRate
+	rv.TotalIncVAT = rv.Total + rv.VAT
+	return rv
+}
+
+// addCost adds c to the period from - to in list. If the last entry in list
+// isn't that period, a new entry is appended.
+func addCost(list []Cost, c Cost, from, to time.Time) []Cost {
+	if n := len(list); n > 0 && list[n-1].From.Equal(from) {
+		list[n-1] = list[n-1].Add(c)
+		return list
+	}
+	var period Cost
+	period.From, period.To = from, to
+	return append(list, period.Add(c))
+}
+
+// Add returns the sum of c and o, keeping the period of c. Taxes are summed by name.
+func (c Cost) Add(o Cost) Cost {
+	rv := c
+	rv.KWh += o.KWh
+	rv.Spot += o.Spot
+	rv.Total += o.Total
+	rv.VAT += o.VAT
+	rv.TotalIncVAT += o.TotalIncVAT
+	rv.Taxes = addTaxes(c.Taxes, o.Taxes)
+	rv.Fixed = addTaxes(c.Fixed, o.Fixed)
+	return rv
+}
+
+// addTaxes sums a and b by name
+func addTaxes(a, b entities.Taxes) entities.Taxes {
+	if len(a) == 0 && len(b) == 0 {
+		return a
+	}
+	rv := make(entities.Taxes, len(a), len(a)+len(b))
+	copy(rv, a)
+outer:
+	for _, t := range b {
+		for i := range rv {
+			if rv[i].Name == t.Name {
+				rv[i].Amount += t.Amount
+				continue outer
+			}
+		}
+		rv = append(rv, t)
+	}
+	return rv
+}
@@ -0,0 +1,121 @@
+package power
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCosts(t *testing.T) {
+	start := time.Date(2022, 1, 31, 22, 0, 0, 0, entities.Location)
+	var fp FullPrices
+	for i := 0; i < 4; i++ {
+		from := start.Add(time.Duration(i) * time.Hour)
+		fp.Contents = append(fp.Contents, entities.FullPrice{
+			ValidFrom: from,
+			ValidTo:   from.Add(time.Hour),
+			RawPrice:  float64(i + 1),
+			Taxes:     entities.Taxes{{Name: "elafgift", Amount: 1}, {Name: "nettarif", Amount: 0.5}},
+		})
+	}
+	fp.From, fp.To = start, start.Add(4*time.Hour)
+
+	cs := entities.Consumptions{
+		// two quarter hours in the first hour
+		{ValidFrom: start, ValidTo: start.Add(15 * time.Minute), KWh: 1},
+		{ValidFrom: start.Add(15 * time.Minute), ValidTo: start.Add(30 * time.Minute), KWh: 1},
+		{ValidFrom: start.Add(time.Hour), ValidTo: start.Add(2 * time.Hour), KWh: 2},
+		// crosses into February
+		{ValidFrom: start.Add(2 * time.Hour), ValidTo: start.Add(3 * time.Hour), KWh: 1},
+		// no price for this one
+		{ValidFrom: start.Add(5 * time.Hour), ValidTo: start.Add(6 * time.Hour), KWh: 1},
+	}
+
+	r := Costs(cs, fp, nil)
+	require.Len(t, r.Hourly, 3)
+	require.Len(t, r.Daily, 2)
+	require.Len(t, r.Monthly, 2)
+	require.Len(t, r.Unpriced, 1)
+
+	first := r.Hourly[0]
+	assert.Equal(t, start, first.From)
+	assert.Equal(t, 2.0, first.KWh)
+	assert.Equal(t, 2.0, first.Spot)
+	assert.Equal(t, entities.Taxes{{Name: "elafgift", Amount: 2}, {Name: "nettarif", Amount: 1}}, first.Taxes)
+	assert.Equal(t, 5.0, first.Total)
+	assert.Equal(t, 1.25, first.VAT)
+	assert.Equal(t, 6.25, first.TotalIncVAT)
+
+	jan := r.Monthly[0]
+	assert.Equal(t, 4.0, jan.KWh)
+	// 2 kWh at 1 + 2 kWh at 2, plus 1.5 in taxes per kWh
+	assert.Equal(t, 12.0, jan.Total)
+	assert.Equal(t, 15.0, jan.TotalIncVAT)
+
+	feb := r.Monthly[1]
+	assert.Equal(t, time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location), feb.From)
+	assert.Equal(t, 4.5, feb.Total)
+	assert.Equal(t, feb.Total, r.Daily[1].Total)
+}
+
+func TestCosts_FixedCharges(t *testing.T) {
+	start := time.Date(2022, 4, 1, 0, 0, 0, 0, entities.Location)
+	var fp FullPrices
+	var cs entities.Consumptions
+	for i := 0; i < 48; i++ {
+		from := start.Add(time.Duration(i) * time.Hour)
+		fp.Contents = append(fp.Contents, entities.FullPrice{ValidFrom: from, ValidTo: from.Add(time.Hour), RawPrice: 1})
+		cs = append(cs, entities.Consumption{ValidFrom: from, ValidTo: from.Add(time.Hour), KWh: 0.5})
+	}
+	fee := start.Add(36 * time.Hour).UTC().Format(time.RFC3339)
+	ch := entities.Charges{
+		{Name: "Netabonnement", Price: 30, Quantity: 1},
+		{Name: "Gebyr", Price: 10, ValidFromDate: fee, Fee: true},
+	}
+
+	r := Costs(cs, fp, ch)
+	require.Len(t, r.Daily, 2)
+	// April has 30 days, so a day is 1/30th of the subscription
+	assert.Equal(t, entities.Taxes{{Name: "Netabonnement", Amount: 1}}, r.Daily[0].Fixed)
+	assert.InDelta(t, 13.0, r.Daily[0].Total, 1e-9)
+	assert.InDelta(t, 1.0/24, r.Hourly[0].Fixed.Total(), 1e-9)
+	assert.InDelta(t, 11.0, r.Daily[1].Fixed.Total(), 1e-9)
+	assert.InDelta(t, 40.0, r.Monthly[0].Fixed.Total(), 1e-9)
+
+	// in UTC, April starts in March
+	est := EstimateMonth(r, ch, start.UTC())
+	assert.Equal(t, start, est.From)
+	// 12 kWh a day for 30 days, at 1 kr. per kWh, plus the subscription and the fee
+	assert.InDelta(t, 360.0, est.KWh, 1e-9)
+	assert.InDelta(t, 400.0, est.Total, 1e-9)
+	assert.InDelta(t, 500.0, est.TotalIncVAT, 1e-9)
+}
+
+func TestCosts_QuarterPrices(t *testing.T) {
+	start := time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location)
+	var fp FullPrices
+	for i := 0; i < 4; i++ {
+		from := start.Add(time.Duration(i) * 15 * time.Minute)
+		fp.Contents = append(fp.Contents, entities.FullPrice{
+			ValidFrom: from,
+			ValidTo:   from.Add(15 * time.Minute),
+			RawPrice:  float64(i + 1),
+			Taxes:     entities.Taxes{{Name: "elafgift", Amount: 1}},
+		})
+	}
+	cs := entities.Consumptions{
+		{ValidFrom: start, ValidTo: start.Add(time.Hour), KWh: 1},
+		// only half of it has prices
+		{ValidFrom: start.Add(30 * time.Minute), ValidTo: start.Add(90 * time.Minute), KWh: 1},
+	}
+
+	r := Costs(cs, fp, nil)
+	require.Len(t, r.Hourly, 1)
+	require.Len(t, r.Unpriced, 1)
+	// the average of the four quarters
+	assert.InDelta(t, 2.5, r.Hourly[0].Spot, 1e-9)
+	assert.InDelta(t, 1.0, r.Hourly[0].Taxes.Total(), 1e-9)
+}
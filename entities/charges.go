@@ -0,0 +1,119 @@
+package entities
+
+import "time"
+
+// Charge is a fixed charge from eloverblik.dk, like a subscription or a fee,
+// which doesn't depend on consumption. Subscriptions are charged per month,
+// fees only once.
+type Charge struct {
+	Name          string  `json:"name"`
+	Description   string  `json:"description"`
+	Owner         string  `json:"owner"`
+	ValidFromDate string  `json:"validFromDate"`
+	ValidToDate   *string `json:"validToDate"`
+	Price         float64 `json:"price"`
+	Quantity      int     `json:"quantity"`
+	Fee           bool    `json:"fee"`
+}
+
+// Charges is a slice of Charge
+type Charges []Charge
+
+// Amount is the price of c, multiplied by the quantity charged
+func (c Charge) Amount() float64 {
+	if c.Quantity == 0 {
+		return c.Price
+	}
+	return c.Price * float64(c.Quantity)
+}
+
+// ValidAt returns true if c is in force at t
+func (c Charge) ValidAt(t time.Time) bool {
+	return validAt(c.ValidFromDate, c.ValidToDate, t)
+}
+
+// For returns the charges in cs for the period from - to, as taxes. Subscriptions
+// are pro-rated by how much of each month the period covers, and fees are
+// included if they're due within the period.
+func (cs Charges) For(from, to time.Time) Taxes {
+	rv := make(Taxes, 0, len(cs))
+	for _, c := range cs {
+		var amount float64
+		if c.Fee {
+			if due, ok := parseDate(c.ValidFromDate); ok && !due.Before(from) && due.Before(to) {
+				amount = c.Amount()
+			}
+		} else {
+			amount = c.Amount() * c.monthsIn(from, to)
+		}
+		if amount != 0 {
+			rv = append(rv, Tax{Name: c.Name, Amount: amount})
+		}
+	}
+	return rv
+}
+
+// PerDay returns the charges in cs pro-rated for the day in Danish time containing t
+func (cs Charges) PerDay(t time.Time) Taxes {
+	t = t.In(Location)
+	from := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, Location)
+	return cs.For(from, from.AddDate(0, 0, 1))
+}
+
+// PerHour returns the charges in cs pro-rated for the hour containing t
+func (cs Charges) PerHour(t time.Time) Taxes {
+	from := t.Truncate(time.Hour)
+	return cs.For(from, from.Add(time.Hour))
+}
+
+// monthsIn returns how many months of from - to c is in force, as a fraction
+// of each calendar month in Danish time covered
+func (c Charge) monthsIn(from, to time.Time) float64 {
+	if vf, ok := parseDate(c.ValidFromDate); ok && vf.After(from) {
+		from = vf
+	}
+	if c.ValidToDate != nil {
+		if vt, ok := parseDate(*c.ValidToDate); ok && vt.Before(to) {
+			to = vt
+		}
+	}
+	from = from.In(Location)
+	var rv float64
+	for from.Before(to) {
+		month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, Location)
+		next := month.AddDate(0, 1, 0)
+		end := to
+		if next.Before(end) {
+			end = next
+		}
+		rv += float64(end.Sub(from)) / float64(next.Sub(month))
+		from = end
+	}
+	return rv
+}
+
+// validAt returns true if t is within the validity dates from and to. A nil to
+// means that there's no end date.
+func validAt(from string, to *string, t time.Time) bool {
+	if vf, ok := parseDate(from); ok && t.Before(vf) {
+		return false
+	}
+	if to != nil {
+		if vt, ok := parseDate(*to); ok && !t.Before(vt) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDate parses the validity dates used by eloverblik
+func parseDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04:05", s, Location)
+	}
+	return t, err == nil
+}
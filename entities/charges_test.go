@@ -0,0 +1,48 @@
+package entities
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCharges_PerDay(t *testing.T) {
+	cs := Charges{
+		{Name: "Abonnement", Price: 31, Quantity: 1},
+		{Name: "Gebyr", Price: 100, Fee: true, ValidFromDate: "2022-12-01T00:00:00"},
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want Taxes
+	}{
+		{name: "december", t: time.Date(2022, 12, 15, 12, 0, 0, 0, Location), want: Taxes{{Name: "Abonnement", Amount: 1}}},
+		// the first of December in Danish time is still November in UTC
+		{name: "utc", t: time.Date(2022, 11, 30, 23, 30, 0, 0, time.UTC), want: Taxes{{Name: "Abonnement", Amount: 1}, {Name: "Gebyr", Amount: 100}}},
+		{name: "november", t: time.Date(2022, 11, 30, 22, 30, 0, 0, time.UTC), want: Taxes{{Name: "Abonnement", Amount: 31.0 / 30}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cs.PerDay(tt.t)
+			require.Len(t, got, len(tt.want))
+			for i := range got {
+				assert.Equal(t, tt.want[i].Name, got[i].Name)
+				assert.InDelta(t, tt.want[i].Amount, got[i].Amount, 1e-9)
+			}
+		})
+	}
+}
+
+func TestCharges_PerHour(t *testing.T) {
+	cs := Charges{{Name: "Abonnement", Price: 31 * 24, Quantity: 1}}
+	// the last hour of November in Danish time
+	got := cs.PerHour(time.Date(2022, 11, 30, 22, 45, 0, 0, time.UTC))
+	require.Len(t, got, 1)
+	assert.InDelta(t, 31.0/30, got[0].Amount, 1e-9)
+	// the first hour of December in Danish time, though it's November in UTC
+	got = cs.PerHour(time.Date(2022, 11, 30, 23, 15, 0, 0, time.UTC))
+	require.Len(t, got, 1)
+	assert.InDelta(t, 1, got[0].Amount, 1e-9)
+}
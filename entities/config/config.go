@@ -4,22 +4,159 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/transport"
 )
 
 // An MID MUST be 18 digits
 const midLength = 18
 
 type confdata struct {
-	Token string `toml:"token"`
-	MID   string `toml:"mid"`
+	Token    string   `toml:"token"`
+	MID      string   `toml:"mid"`
+	Area     string   `toml:"area"`
+	Store    string   `toml:"store"`
+	Notify   Notify   `toml:"notify"`
+	Influx   Influx   `toml:"influx"`
+	MQTT     MQTT     `toml:"mqtt"`
+	HTTP     HTTP     `toml:"http"`
+	Upstream Upstream `toml:"upstream"`
 }
 
 type Config struct {
-	token string `toml:"token"`
-	mid   string `toml:"mid"`
+	token    string `toml:"token"`
+	mid      string `toml:"mid"`
+	area     string `toml:"area"`
+	store    string
+	notify   Notify
+	influx   Influx
+	mqtt     MQTT
+	http     HTTP
+	upstream Upstream
+}
+
+// Influx configures writing prices to InfluxDB v2
+type Influx struct {
+	URL    string `toml:"url"`
+	Org    string `toml:"org"`
+	Bucket string `toml:"bucket"`
+	Token  string `toml:"token"`
+}
+
+// MQTT configures publishing prices to an MQTT broker
+type MQTT struct {
+	// Broker is the URL of the broker, like tcp://localhost:1883
+	Broker   string `toml:"broker"`
+	ClientID string `toml:"client_id"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// Prefix is prepended to the topics prices are published to. Defaults to "power".
+	Prefix string `toml:"prefix"`
+	// DiscoveryPrefix is the Home Assistant discovery prefix. Defaults to "homeassistant".
+	DiscoveryPrefix string `toml:"discovery_prefix"`
+	// Poll is how often to check for tomorrow's prices until they're published, like "10m"
+	Poll string `toml:"poll"`
+}
+
+// PollInterval returns how often to check for tomorrow's prices. Defaults to 10 minutes.
+func (m MQTT) PollInterval() time.Duration {
+	if d, err := time.ParseDuration(m.Poll); err == nil && d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+// HTTP configures requests to the upstream APIs. Durations are like "30s".
+type HTTP struct {
+	// Timeout is the timeout of each attempt at a request
+	Timeout string `toml:"timeout"`
+	// Retries is how many times to retry a request failing with a network
+	// error, 429 or 5xx. 0 disables retries.
+	Retries *int `toml:"retries"`
+	// Backoff is the wait before the first retry, doubling for each retry after that
+	Backoff    string `toml:"backoff"`
+	MaxBackoff string `toml:"max_backoff"`
+	// BreakerFailures is how many failed requests in a row make us stop
+	// calling an upstream for BreakerCooldown
+	BreakerFailures int    `toml:"breaker_failures"`
+	BreakerCooldown string `toml:"breaker_cooldown"`
+}
+
+// Options returns the transport options configured in h. Anything not
+// configured is left at the default.
+func (h HTTP) Options() transport.Options {
+	o := transport.Options{BreakerFailures: h.BreakerFailures}
+	o.Timeout, _ = time.ParseDuration(h.Timeout)
+	o.Backoff, _ = time.ParseDuration(h.Backoff)
+	o.MaxBackoff, _ = time.ParseDuration(h.MaxBackoff)
+	o.BreakerCooldown, _ = time.ParseDuration(h.BreakerCooldown)
+	if h.Retries != nil {
+		o.Retries = *h.Retries
+		if o.Retries == 0 {
+			o.Retries = -1
+		}
+	}
+	return o
+}
+
+// durations returns the durations in h by name
+func (h HTTP) durations() map[string]string {
+	return map[string]string{"timeout": h.Timeout, "backoff": h.Backoff, "max_backoff": h.MaxBackoff, "breaker_cooldown": h.BreakerCooldown}
+}
+
+// Upstream configures the base URLs of the upstream APIs, e.g. to use a proxy
+// or a stand-in. Empty URLs are left at the defaults.
+type Upstream struct {
+	Energidataservice string `toml:"energidataservice"`
+	Eloverblik        string `toml:"eloverblik"`
+	Nationalbanken    string `toml:"nationalbanken"`
+}
+
+// urls returns the URLs in u by name
+func (u Upstream) urls() map[string]string {
+	return map[string]string{"energidataservice": u.Energidataservice, "eloverblik": u.Eloverblik, "nationalbanken": u.Nationalbanken}
+}
+
+// Notify configures price notifications
+type Notify struct {
+	// Below and Above are thresholds for the price inc. VAT. nil disables them.
+	Below    *float64 `toml:"below"`
+	Above    *float64 `toml:"above"`
+	Tomorrow bool     `toml:"tomorrow"`
+	// Interval is how often to check prices, like "5m"
+	Interval  string `toml:"interval"`
+	Webhook   string `toml:"webhook"`
+	Ntfy      string `toml:"ntfy"`
+	NtfyToken string `toml:"ntfy_token"`
+	SMTP      SMTP   `toml:"smtp"`
+}
+
+// SMTP configures sending notifications by email
+type SMTP struct {
+	Addr     string   `toml:"addr"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+}
+
+// Enabled returns true if there's anything to notify about, and anywhere to send it
+func (n Notify) Enabled() bool {
+	sinks := n.Webhook != "" || n.Ntfy != "" || n.SMTP.Addr != ""
+	return sinks && (n.Below != nil || n.Above != nil || n.Tomorrow)
+}
+
+// CheckInterval returns how often to check prices. Defaults to 5 minutes.
+func (n Notify) CheckInterval() time.Duration {
+	if d, err := time.ParseDuration(n.Interval); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
 }
 
 var conf Config
@@ -45,6 +182,41 @@ func (c Config) MID() string {
 	return c.mid
 }
 
+// Area is the price area, DK1 or DK2. Empty if not configured.
+func (c Config) Area() string {
+	return c.area
+}
+
+// Store is the path to the file prices and tariffs are stored in. Empty if not configured.
+func (c Config) Store() string {
+	return c.store
+}
+
+// Influx is the configuration of InfluxDB
+func (c Config) Influx() Influx {
+	return c.influx
+}
+
+// MQTT is the configuration of the MQTT broker
+func (c Config) MQTT() MQTT {
+	return c.mqtt
+}
+
+// HTTP is the configuration of requests to the upstream APIs
+func (c Config) HTTP() HTTP {
+	return c.http
+}
+
+// Upstream is the configuration of the base URLs of the upstream APIs
+func (c Config) Upstream() Upstream {
+	return c.upstream
+}
+
+// Notify is the configuration of price notifications
+func (c Config) Notify() Notify {
+	return c.notify
+}
+
 func (c *Config) Load(filename string) error {
 	tomlData, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -57,12 +229,50 @@ func (c *Config) Load(filename string) error {
 	}
 	c.mid = d.MID
 	c.token = d.Token
+	c.area = d.Area
+	c.store = d.Store
+	c.notify = d.Notify
+	c.influx = d.Influx
+	c.mqtt = d.MQTT
+	c.http = d.HTTP
+	c.upstream = d.Upstream
 	if len(c.mid) != midLength {
 		return fmt.Errorf("MID is not %d digits", midLength)
 	}
 	if c.token == "" {
 		return errors.New("empty token")
 	}
+	if _, err := energidataservice.ParseArea(c.area); err != nil {
+		return err
+	}
+	if c.notify.Interval != "" {
+		if _, err := time.ParseDuration(c.notify.Interval); err != nil {
+			return fmt.Errorf("notify interval: %w", err)
+		}
+	}
+	if c.mqtt.Poll != "" {
+		if _, err := time.ParseDuration(c.mqtt.Poll); err != nil {
+			return fmt.Errorf("mqtt poll: %w", err)
+		}
+	}
+	for name, v := range c.http.durations() {
+		if v != "" {
+			if _, err := time.ParseDuration(v); err != nil {
+				return fmt.Errorf("http %s: %w", name, err)
+			}
+		}
+	}
+	for name, v := range c.upstream.urls() {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("upstream %s: '%s' is not an http(s) URL", name, v)
+		}
+	}
+	if c.notify.SMTP.Addr != "" && (c.notify.SMTP.From == "" || len(c.notify.SMTP.To) == 0) {
+		return errors.New("notify smtp needs both from and to")
+	}
 	return nil
 }
 
@@ -70,4 +280,5 @@ func (c *Config) Load(filename string) error {
 func Set(in interfaces.Configurator) {
 	conf.token = in.Token()
 	conf.mid = in.MID()
+	conf.area = in.Area()
 }
@@ -0,0 +1,34 @@
+package entities
+
+import "time"
+
+// Consumption is the metered amount of power (in kWh) used in a period
+type Consumption struct {
+	ValidFrom time.Time `json:"valid_from"`
+	ValidTo   time.Time `json:"valid_to"`
+	KWh       float64   `json:"kwh"`
+	Quality   string    `json:"quality"`
+}
+
+// Consumptions is a slice of Consumption
+type Consumptions []Consumption
+
+// Total summarizes the consumption in cs
+func (cs Consumptions) Total() float64 {
+	var rv float64
+	for _, c := range cs {
+		rv += c.KWh
+	}
+	return rv
+}
+
+// Range returns the readings in cs that are inside the window from - to
+func (cs Consumptions) Range(from, to time.Time) Consumptions {
+	rv := make(Consumptions, 0, len(cs))
+	for _, c := range cs {
+		if !c.ValidFrom.Before(from) && !c.ValidTo.After(to) {
+			rv = append(rv, c)
+		}
+	}
+	return rv
+}
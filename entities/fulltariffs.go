@@ -0,0 +1,201 @@
+package entities
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// FullTariffs is the data format returned from eloverblik, containing the tariffs,
+// subscriptions and fees for a metering point.
+type FullTariffs struct {
+	Result []struct {
+		Result struct {
+			MeteringPointId string `json:"meteringPointId"`
+			Subscriptions   []struct {
+				SubscriptionId interface{} `json:"subscriptionId"`
+				Name           string      `json:"name"`
+				Description    string      `json:"description"`
+				Owner          string      `json:"owner"`
+				ValidFromDate  string      `json:"validFromDate"`
+				ValidToDate    *string     `json:"validToDate"`
+				Price          float64     `json:"price"`
+				Quantity       int         `json:"quantity"`
+			} `json:"subscriptions"`
+			Fees []struct {
+				FeeId         interface{} `json:"feeId"`
+				Name          string      `json:"name"`
+				Description   string      `json:"description"`
+				Owner         string      `json:"owner"`
+				ValidFromDate string      `json:"validFromDate"`
+				ValidToDate   *string     `json:"validToDate"`
+				Price         float64     `json:"price"`
+				Quantity      int         `json:"quantity"`
+			} `json:"fees"`
+			Tariffs []struct {
+				TariffId      interface{} `json:"tariffId"`
+				Name          string      `json:"name"`
+				Description   string      `json:"description"`
+				Owner         string      `json:"owner"`
+				PeriodType    string      `json:"periodType"`
+				ValidFromDate string      `json:"validFromDate"`
+				ValidToDate   *string     `json:"validToDate"`
+				Prices        []struct {
+					Position string  `json:"position"`
+					Price    float64 `json:"price"`
+				} `json:"prices"`
+			} `json:"tariffs"`
+		} `json:"result"`
+		Success       bool        `json:"success"`
+		ErrorCode     int         `json:"errorCode"`
+		ErrorCodeEnum string      `json:"errorCodeEnum"`
+		ErrorText     string      `json:"errorText"`
+		Id            string      `json:"id"`
+		StackTrace    interface{} `json:"stackTrace"`
+	} `json:"result"`
+	ts time.Time `json:"-"`
+}
+
+func (ft FullTariffs) UpdatedAt() time.Time {
+	return ft.ts
+}
+
+// SetUpdatedAt sets when ft was fetched, like when it's loaded from storage
+func (ft *FullTariffs) SetUpdatedAt(t time.Time) {
+	ft.ts = t
+}
+
+// positionTariff is a tariff with its prices by position
+type positionTariff struct {
+	tariff Tariff
+	prices []float64
+}
+
+// Index tariffs by the period they're valid in, and position (hour). A new
+// period starts whenever any tariff starts or stops being in force.
+func (ft FullTariffs) Index() TariffIndex {
+	var tariffs []positionTariff
+	var bounds []time.Time
+	for _, res := range ft.Result {
+		for _, tar := range res.Result.Tariffs {
+			pt := positionTariff{
+				tariff: Tariff{
+					TariffId:      tar.TariffId,
+					Name:          tar.Name,
+					Description:   tar.Description,
+					Owner:         tar.Owner,
+					PeriodType:    tar.PeriodType,
+					ValidFromDate: tar.ValidFromDate,
+					ValidToDate:   tar.ValidToDate,
+				},
+				prices: make([]float64, len(tar.Prices)),
+			}
+			for i, p := range tar.Prices {
+				pos, _ := strconv.Atoi(p.Position)
+				if pos < 1 || pos > len(tar.Prices) {
+					pos = i + 1
+				}
+				pt.prices[pos-1] = p.Price
+			}
+			tariffs = append(tariffs, pt)
+			from, to := pt.tariff.Validity()
+			for _, b := range []time.Time{from, to} {
+				if !b.IsZero() {
+					bounds = append(bounds, b)
+				}
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+
+	var rv TariffIndex
+	// the first period is before any known validity date, and only contains tariffs without one
+	starts := append([]time.Time{{}}, bounds...)
+	for i, start := range starts {
+		if i > 0 && start.Equal(starts[i-1]) {
+			continue
+		}
+		var end time.Time
+		for _, b := range starts[i+1:] {
+			if b.After(start) {
+				end = b
+				break
+			}
+		}
+		var valid []positionTariff
+		for _, pt := range tariffs {
+			if start.IsZero() {
+				if from, _ := pt.tariff.Validity(); !from.IsZero() {
+					continue
+				}
+			} else if !pt.tariff.ValidAt(start) {
+				continue
+			}
+			valid = append(valid, pt)
+		}
+		if len(valid) == 0 {
+			continue
+		}
+		rv = append(rv, TariffPeriod{
+			ValidFrom: start,
+			ValidTo:   end,
+			Positions: indexPositions(valid),
+		})
+	}
+	return rv
+}
+
+// indexPositions indexes tariffs by position (hour)
+func indexPositions(tariffs []positionTariff) map[int][]Tariff {
+	rv := make(map[int][]Tariff)
+	var count int
+	for _, pt := range tariffs {
+		count = max(count, len(pt.prices))
+	}
+	for _, pt := range tariffs {
+		if len(pt.prices) == 0 {
+			continue
+		}
+		for i := 0; i < count; i++ {
+			pos := 0
+			if len(pt.prices) > i {
+				pos = i
+			}
+			ptariff := pt.tariff
+			ptariff.Price = pt.prices[pos]
+			rv[i] = append(rv[i], ptariff)
+		}
+	}
+	return rv
+}
+
+// Charges returns the subscriptions and fees in ft
+func (ft FullTariffs) Charges() Charges {
+	var rv Charges
+	for _, res := range ft.Result {
+		for _, s := range res.Result.Subscriptions {
+			rv = append(rv, Charge{
+				Name:          s.Name,
+				Description:   s.Description,
+				Owner:         s.Owner,
+				ValidFromDate: s.ValidFromDate,
+				ValidToDate:   s.ValidToDate,
+				Price:         s.Price,
+				Quantity:      s.Quantity,
+			})
+		}
+		for _, f := range res.Result.Fees {
+			rv = append(rv, Charge{
+				Name:          f.Name,
+				Description:   f.Description,
+				Owner:         f.Owner,
+				ValidFromDate: f.ValidFromDate,
+				ValidToDate:   f.ValidToDate,
+				Price:         f.Price,
+				Quantity:      f.Quantity,
+				Fee:           true,
+			})
+		}
+	}
+	return rv
+}
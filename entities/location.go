@@ -0,0 +1,47 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+
+	// embed the time zone database, so Danish time works regardless of the host
+	_ "time/tzdata"
+)
+
+// Location is Danish time. Tariffs, days and months all follow it, regardless
+// of the time zone of the host.
+var Location = mustLoadLocation("Europe/Copenhagen")
+
+func mustLoadLocation(name string) *time.Location {
+	l, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// TariffPosition returns the tariff position (0-23) for t, which is the hour of
+// the day on the clock in Danish time. On the day daylight saving time starts,
+// there's no position 2, and on the day it ends, both hours starting at 02:00
+// are position 2.
+func TariffPosition(t time.Time) int {
+	return t.In(Location).Hour()
+}
+
+// ParseTime parses s as either an RFC 3339 time, or a time of day like 15:04,
+// meaning the next time the clock is that in Danish time, after now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	c, err := time.ParseInLocation("15:04", s, Location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither RFC 3339 nor HH:MM", s)
+	}
+	now = now.In(Location)
+	rv := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, Location)
+	if !rv.After(now) {
+		rv = rv.AddDate(0, 0, 1)
+	}
+	return rv, nil
+}
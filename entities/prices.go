@@ -1,6 +1,12 @@
 package entities
 
-import "time"
+import (
+	"context"
+	"time"
+)
+
+// PriceSource returns prices from `from` to `to`, as far as they're available
+type PriceSource func(ctx context.Context, from, to time.Time) ([]FullPrice, error)
 
 // FullPrice is the final price per kWh, when all taxes are taken into account.
 type FullPrice struct {
@@ -17,19 +23,30 @@ type FullPrice struct {
 
 // Elspotprice is the raw per price data
 type Elspotprice struct {
-	HourUTC       pTime    `json:"HourUTC"`
-	HourDK        pTime    `json:"HourDK"`
+	HourUTC       PTime    `json:"HourUTC"`
+	HourDK        PTime    `json:"HourDK"`
 	PriceArea     string   `json:"PriceArea"`
 	SpotPriceDKK  *float64 `json:"SpotPriceDKK"`
 	DKKEstimated  bool     `json:"dkk_estimated"`
 	EstimatedRate float64  `json:"rate,omitempty"`
 	SpotPriceEUR  float64  `json:"SpotPriceEUR"`
 	Typename      string   `json:"__typename"`
+	// Resolution is the length of the period the price is for. Zero means an hour.
+	Resolution time.Duration `json:"resolution,omitempty"`
+}
+
+// Duration returns the length of the period the price is for
+func (e Elspotprice) Duration() time.Duration {
+	if e.Resolution == 0 {
+		return time.Hour
+	}
+	return e.Resolution
 }
 
-type pTime time.Time
+// PTime is a time, as formatted by energidataservice
+type PTime time.Time
 
-func (t *pTime) UnmarshalJSON(b []byte) (err error) {
+func (t *PTime) UnmarshalJSON(b []byte) (err error) {
 	s := string(b)
 
 	// Get rid of the quotes "" around the value.
@@ -42,10 +59,14 @@ func (t *pTime) UnmarshalJSON(b []byte) (err error) {
 	if err != nil {
 		o, err = time.Parse("2006-01-02T15:04:05", s)
 	}
-	*t = pTime(o)
+	*t = PTime(o)
 	return
 }
 
+func (t PTime) MarshalJSON() ([]byte, error) {
+	return time.Time(t).MarshalJSON()
+}
+
 // InWindow returns true if fb is inside the window from - to
 func (fp FullPrice) InWindow(from, to time.Time) bool {
 	if to.Before(from) {
@@ -1,5 +1,7 @@
 package entities
 
+import "time"
+
 // Tariff is a flattened version of a tariff from eloverblik.dk
 type Tariff struct {
 	TariffId      interface{} `json:"tariffId"`
@@ -30,7 +32,16 @@ type TariffMetadata struct {
 	Positions     IntSet
 }
 
-type TariffIndex map[int][]Tariff
+// TariffPeriod is the tariffs in force from ValidFrom until ValidTo, indexed by
+// position (hour). A zero ValidFrom or ValidTo means the period is open in that end.
+type TariffPeriod struct {
+	ValidFrom time.Time
+	ValidTo   time.Time
+	Positions map[int][]Tariff
+}
+
+// TariffIndex is a list of tariff periods, sorted by time
+type TariffIndex []TariffPeriod
 
 // Tax converts a Tariff to a Tax
 func (t Tariff) Tax() Tax {
@@ -58,13 +69,46 @@ func (ts Taxes) Total() float64 {
 	return rv
 }
 
+// Validity returns the period t is in force. A zero time means there's no limit in that end.
+func (t Tariff) Validity() (from, to time.Time) {
+	from, _ = parseDate(t.ValidFromDate)
+	if t.ValidToDate != nil {
+		to, _ = parseDate(*t.ValidToDate)
+	}
+	return from, to
+}
+
+// ValidAt returns true if t is in force at ts
+func (t Tariff) ValidAt(ts time.Time) bool {
+	return validAt(t.ValidFromDate, t.ValidToDate, ts)
+}
+
+// Contains returns true if t is inside the period tp
+func (tp TariffPeriod) Contains(t time.Time) bool {
+	if !tp.ValidFrom.IsZero() && t.Before(tp.ValidFrom) {
+		return false
+	}
+	return tp.ValidTo.IsZero() || t.Before(tp.ValidTo)
+}
+
 // AtPos returns the Tariff at index p, if it exists. Otherwise, returns from the default position (0)
-func (t TariffIndex) AtPos(p int) Tariffs {
-	if v, ok := t[p]; ok {
+func (tp TariffPeriod) AtPos(p int) Tariffs {
+	if v, ok := tp.Positions[p]; ok {
 		return v
 	}
-	if v, ok := t[0]; ok {
+	if v, ok := tp.Positions[0]; ok {
 		return v
 	}
 	return nil
 }
+
+// At returns the tariffs in force at ts, at the position for ts in Danish time.
+// If no tariffs are known for ts, returns nil
+func (t TariffIndex) At(ts time.Time) Tariffs {
+	for _, tp := range t {
+		if tp.Contains(ts) {
+			return tp.AtPos(TariffPosition(ts))
+		}
+	}
+	return nil
+}
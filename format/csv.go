@@ -0,0 +1,69 @@
+package format
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+)
+
+// CSV writes prices as comma separated values. See Delimited.
+func CSV(w io.Writer, prices []entities.FullPrice) error {
+	return Delimited(w, prices, ',')
+}
+
+// TSV writes prices as tab separated values. See Delimited.
+func TSV(w io.Writer, prices []entities.FullPrice) error {
+	return Delimited(w, prices, '\t')
+}
+
+// Delimited writes prices as values separated by the rune comma, like ',' or
+// '\t', starting with a header row. Each tax gets a column, in the order
+// they're first seen in, which is the same for all rows. Taxes not in force for
+// a price are left empty.
+func Delimited(w io.Writer, prices []entities.FullPrice, comma rune) error {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range prices {
+		for _, t := range p.Taxes {
+			if !seen[t.Name] {
+				seen[t.Name] = true
+				names = append(names, t.Name)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := append([]string{"valid_from", "valid_to", "spot_price_ex_vat"}, names...)
+	header = append(header, "taxes_subtotal_ex_vat", "total_ex_vat", "total_inc_vat", "dkk_estimated", "rate")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, p := range prices {
+		taxes := make(map[string]float64)
+		for _, t := range p.Taxes {
+			taxes[t.Name] += t.Amount
+		}
+		row := []string{p.ValidFrom.Format(time.RFC3339), p.ValidTo.Format(time.RFC3339), float(p.RawPrice)}
+		for _, n := range names {
+			var v string
+			if a, ok := taxes[n]; ok {
+				v = float(a)
+			}
+			row = append(row, v)
+		}
+		var rate string
+		if p.Estimated {
+			rate = float(p.EstimatedRate)
+		}
+		row = append(row, float(p.TaxesSubTotal), float(p.Total), float(p.TotalIncVAT), strconv.FormatBool(p.Estimated), rate)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,40 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelimited(t *testing.T) {
+	from := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	prices := []entities.FullPrice{
+		{
+			ValidFrom: from, ValidTo: from.Add(time.Hour),
+			Taxes:    entities.Taxes{{Name: "Elafgift", Amount: 0.7}, {Name: "Nettarif, C", Amount: 0.2}},
+			RawPrice: 1, TaxesSubTotal: 0.9, Total: 1.9, TotalIncVAT: 2.375,
+		},
+		{
+			ValidFrom: from.Add(time.Hour), ValidTo: from.Add(2 * time.Hour),
+			// a different order, and a tax not seen before
+			Taxes:    entities.Taxes{{Name: "Systemtarif", Amount: 0.05}, {Name: "Elafgift", Amount: 0.7}},
+			RawPrice: 1, TaxesSubTotal: 0.75, Total: 1.75, TotalIncVAT: 2.1875, Estimated: true, EstimatedRate: 7.44,
+		},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, CSV(&b, prices))
+	assert.Equal(t, `valid_from,valid_to,spot_price_ex_vat,Elafgift,"Nettarif, C",Systemtarif,taxes_subtotal_ex_vat,total_ex_vat,total_inc_vat,dkk_estimated,rate
+2022-02-01T00:00:00+01:00,2022-02-01T01:00:00+01:00,1,0.7,0.2,,0.9,1.9,2.375,false,
+2022-02-01T01:00:00+01:00,2022-02-01T02:00:00+01:00,1,0.7,,0.05,0.75,1.75,2.1875,true,7.44
+`, b.String())
+
+	b.Reset()
+	require.NoError(t, TSV(&b, prices[:1]))
+	assert.Equal(t, "valid_from\tvalid_to\tspot_price_ex_vat\tElafgift\tNettarif, C\ttaxes_subtotal_ex_vat\ttotal_ex_vat\ttotal_inc_vat\tdkk_estimated\trate\n"+
+		"2022-02-01T00:00:00+01:00\t2022-02-01T01:00:00+01:00\t1\t0.7\t0.2\t0.9\t1.9\t2.375\tfalse\t\n", b.String())
+}
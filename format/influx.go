@@ -0,0 +1,78 @@
+// Package format encodes prices in formats other than JSON
+package format
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adamhassel/power/entities"
+)
+
+// Measurement is the InfluxDB measurement prices are written to
+const Measurement = "power_price"
+
+// taxPrefix is prepended to the names of taxes, to keep them apart from the other fields
+const taxPrefix = "tax_"
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// Influx encodes prices as InfluxDB line protocol, one line per price,
+// timestamped with the start of the period in nanoseconds. Each tax is a
+// field, and tags are added to every line.
+func Influx(prices []entities.FullPrice, tags map[string]string) []byte {
+	var tagstr strings.Builder
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	// line protocol performs best with sorted tags
+	sort.Strings(keys)
+	for _, k := range keys {
+		tagstr.WriteString("," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(tags[k]))
+	}
+
+	var b bytes.Buffer
+	for _, p := range prices {
+		b.WriteString(measurementEscaper.Replace(Measurement))
+		b.WriteString(tagstr.String())
+		b.WriteString(" spot_price_ex_vat=" + float(p.RawPrice))
+		b.WriteString(",taxes_subtotal_ex_vat=" + float(p.TaxesSubTotal))
+		b.WriteString(",total_ex_vat=" + float(p.Total))
+		b.WriteString(",total_inc_vat=" + float(p.TotalIncVAT))
+		b.WriteString(",dkk_estimated=" + strconv.FormatBool(p.Estimated))
+		if p.Estimated {
+			b.WriteString(",rate=" + float(p.EstimatedRate))
+		}
+		for _, t := range sumTaxes(p.Taxes) {
+			b.WriteString("," + keyEscaper.Replace(taxPrefix+t.Name) + "=" + float(t.Amount))
+		}
+		b.WriteString(" " + strconv.FormatInt(p.ValidFrom.UnixNano(), 10) + "\n")
+	}
+	return b.Bytes()
+}
+
+// sumTaxes adds up taxes with the same name, keeping the order they're first seen in
+func sumTaxes(taxes entities.Taxes) entities.Taxes {
+	rv := make(entities.Taxes, 0, len(taxes))
+	idx := make(map[string]int)
+	for _, t := range taxes {
+		if i, ok := idx[t.Name]; ok {
+			rv[i].Amount += t.Amount
+			continue
+		}
+		idx[t.Name] = len(rv)
+		rv = append(rv, t)
+	}
+	return rv
+}
+
+func float(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
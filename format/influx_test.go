@@ -0,0 +1,31 @@
+package format
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInflux(t *testing.T) {
+	from := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	prices := []entities.FullPrice{
+		{
+			ValidFrom: from, ValidTo: from.Add(time.Hour),
+			Taxes:         entities.Taxes{{Name: "Elafgift", Amount: 0.7}, {Name: "Nettarif C time", Amount: 0.2}, {Name: "Nettarif C time", Amount: 0.05}},
+			RawPrice:      1.5,
+			TaxesSubTotal: 0.95,
+			Total:         2.45,
+			TotalIncVAT:   3.0625,
+		},
+		{
+			ValidFrom: from.Add(time.Hour), ValidTo: from.Add(2 * time.Hour),
+			Estimated: true, EstimatedRate: 7.44, RawPrice: 1, Total: 1, TotalIncVAT: 1.25,
+		},
+	}
+	want := `power_price,area=DK2,mid=5700\ 1 spot_price_ex_vat=1.5,taxes_subtotal_ex_vat=0.95,total_ex_vat=2.45,total_inc_vat=3.0625,dkk_estimated=false,tax_Elafgift=0.7,tax_Nettarif\ C\ time=0.25 1643673600000000000
+power_price,area=DK2,mid=5700\ 1 spot_price_ex_vat=1,taxes_subtotal_ex_vat=0,total_ex_vat=1,total_inc_vat=1.25,dkk_estimated=true,rate=7.44 1643677200000000000
+`
+	assert.Equal(t, want, string(Influx(prices, map[string]string{"mid": "5700 1", "area": "DK2", "empty": ""})))
+}
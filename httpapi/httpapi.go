@@ -1,15 +1,22 @@
 package httpapi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/adamhassel/power"
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/format"
 	"github.com/adamhassel/power/interfaces"
-	"github.com/adamhassel/power/repos/eloverblik"
 	"github.com/adamhassel/power/repos/energidataservice"
 )
 
@@ -18,25 +25,29 @@ func writeReply(w http.ResponseWriter, body string, status int) {
 	w.Write([]byte(body))
 }
 
-func GetPowerPricesConfigHandler(c interfaces.Configurator, ignoreMissingTariffs bool) func(http.ResponseWriter, *http.Request) {
-	return GetPowerPrices(c, ignoreMissingTariffs)
+func GetPowerPricesConfigHandler(s *power.PriceService, c interfaces.Configurator, ignoreMissingTariffs bool) func(http.ResponseWriter, *http.Request) {
+	return GetPowerPrices(s, c, ignoreMissingTariffs)
 }
 
 // GetPowerPrices is a handler to fetch and display power prices
 // * handler to return power data
 // * cache tariffs in mem to not have to get them all the time.
-func GetPowerPrices(c interfaces.Configurator, ignoreMissingTariffs bool) func(w http.ResponseWriter, req *http.Request) {
+// Prices are JSON, unless CSV or TSV is asked for with the `format` parameter or the Accept header.
+func GetPowerPrices(s *power.PriceService, c interfaces.Configurator, ignoreMissingTariffs bool) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		// default, get 12 hours
 		h := 12
+		outFormat, err := negotiate(req)
+		if err != nil {
+			writeReply(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// refresh tariffs unconditionally if they're more than 24 hrs old
-		if time.Now().Sub(eloverblik.FullTariffsCached.UpdatedAt()) > 24*time.Hour {
-			if err := eloverblik.PreloadTariffs(c); err != nil {
-				if !ignoreMissingTariffs {
-					writeReply(w, err.Error(), http.StatusBadGateway)
-					return
-				}
+		if err := s.RefreshTariffs(req.Context(), c); err != nil {
+			if !ignoreMissingTariffs {
+				writeReply(w, err.Error(), http.StatusBadGateway)
+				return
 			}
 		}
 		params := req.URL.Query()
@@ -49,21 +60,28 @@ func GetPowerPrices(c interfaces.Configurator, ignoreMissingTariffs bool) func(w
 				}
 			}
 		}
-		now := time.Now().Truncate(time.Hour)
-		p, err := power.Prices(now, now.Add(time.Duration(h)*time.Hour), c, ignoreMissingTariffs)
-
-		//p, err := getSpotPrices(h)
+		area, err := areaParam(req.Context(), s, params, c)
+		if err != nil {
+			writeReply(w, err.Error(), areaStatus(err))
+			return
+		}
+		now := s.Now().Truncate(time.Hour)
+		p, err := s.PricesInArea(req.Context(), now, now.Add(time.Duration(h)*time.Hour), area, c, ignoreMissingTariffs)
 		if err != nil {
 			writeReply(w, err.Error(), http.StatusBadGateway)
 			return
 		}
-		/*
-			combined := power.Summarize(p, eloverblik.FullTariffsCached)
-
-		*/
+		switch outFormat {
+		case formatCSV:
+			renderDelimited(w, req, "text/csv; charset=utf-8", p, ',')
+			return
+		case formatTSV:
+			renderDelimited(w, req, "text/tab-separated-values; charset=utf-8", p, '\t')
+			return
+		}
 		var simple bool
-		if s, ok := params["simple"]; ok {
-			if len(s) > 0 && s[0] != "" {
+		if v, ok := params["simple"]; ok {
+			if len(v) > 0 && v[0] != "" {
 				simple = true
 			}
 		}
@@ -89,22 +107,220 @@ func GetPowerPrices(c interfaces.Configurator, ignoreMissingTariffs bool) func(w
 	}
 }
 
-func renderJson(w http.ResponseWriter, data interface{}) {
-	output, err := json.Marshal(data)
+// Output formats
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatTSV  = "tsv"
+)
+
+// negotiate returns the output format asked for in the `format` query
+// parameter, or else the first one supported in the Accept header. Defaults to JSON.
+func negotiate(req *http.Request) (string, error) {
+	if f := req.URL.Query().Get("format"); f != "" {
+		switch f = strings.ToLower(f); f {
+		case formatJSON, formatCSV, formatTSV:
+			return f, nil
+		}
+		return "", fmt.Errorf("unknown format '%s', must be %s, %s or %s", f, formatJSON, formatCSV, formatTSV)
+	}
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mt := range strings.Split(accept, ",") {
+			mt = strings.TrimSpace(strings.SplitN(mt, ";", 2)[0])
+			switch strings.ToLower(mt) {
+			case "application/json":
+				return formatJSON, nil
+			case "text/csv":
+				return formatCSV, nil
+			case "text/tab-separated-values":
+				return formatTSV, nil
+			}
+		}
+	}
+	return formatJSON, nil
+}
+
+// areaParam returns the price area in the `area` query parameter, or the area from c if it isn't set
+func areaParam(ctx context.Context, s *power.PriceService, params url.Values, c interfaces.Configurator) (energidataservice.Area, error) {
+	if a := params.Get("area"); a != "" {
+		return energidataservice.ParseArea(a)
+	}
+	return s.Area(ctx, c)
+}
+
+// areaStatus returns the HTTP status for an error from areaParam
+func areaStatus(err error) int {
+	if errors.Is(err, power.ErrNoArea) {
+		return http.StatusBadGateway
+	}
+	return http.StatusBadRequest
+}
+
+// timeParam parses the query parameter `name` as a time relative to now, returning def if it isn't set
+func timeParam(params url.Values, name string, now, def time.Time) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	t, err := entities.ParseTime(v, now)
 	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// GetCheapestWindow is a handler to find the cheapest contiguous window of a given `duration` (like 3h or 90m),
+// starting no earlier than `from` and ending no later than `deadline`. Both default to as wide as possible.
+func GetCheapestWindow(s *power.PriceService, c interfaces.Configurator, ignoreMissingTariffs bool) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		d, err := time.ParseDuration(params.Get("duration"))
+		if err != nil {
+			writeReply(w, fmt.Sprintf("Error parsing duration: %s", err), http.StatusBadRequest)
+			return
+		}
+		now := s.Now()
+		from, err := timeParam(params, "from", now, now)
+		if err != nil {
+			writeReply(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deadline, err := timeParam(params, "deadline", now, time.Time{})
+		if err != nil {
+			writeReply(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		area, err := areaParam(req.Context(), s, params, c)
+		if err != nil {
+			writeReply(w, err.Error(), areaStatus(err))
+			return
+		}
+		win, err := s.CheapestWindow(req.Context(), d, from, deadline, area, c, ignoreMissingTariffs)
+		if errors.Is(err, power.ErrNoWindow) {
+			writeReply(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeReply(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		renderJson(w, win)
+	}
+}
+
+// GetCheapestSlots is a handler to pick the cheapest slots to be on in for a total `duration`, not necessarily
+// contiguous, between `from` and `deadline`. `minblock` is the shortest time to stay on, `maxgap` the longest time
+// to stay off between blocks, and any slot priced below `below` is always on.
+func GetCheapestSlots(s *power.PriceService, c interfaces.Configurator, ignoreMissingTariffs bool) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		var o power.SlotOptions
+		var err error
+		if o.Duration, err = time.ParseDuration(params.Get("duration")); err != nil {
+			writeReply(w, fmt.Sprintf("Error parsing duration: %s", err), http.StatusBadRequest)
+			return
+		}
+		for name, d := range map[string]*time.Duration{"minblock": &o.MinBlock, "maxgap": &o.MaxGap} {
+			if v := params.Get(name); v != "" {
+				if *d, err = time.ParseDuration(v); err != nil {
+					writeReply(w, fmt.Sprintf("Error parsing %s: %s", name, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if v := params.Get("below"); v != "" {
+			below, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				writeReply(w, fmt.Sprintf("Error parsing %s as a number", v), http.StatusBadRequest)
+				return
+			}
+			o.AlwaysOnBelow = &below
+		}
+		now := s.Now()
+		if o.From, err = timeParam(params, "from", now, now); err != nil {
+			writeReply(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if o.Deadline, err = timeParam(params, "deadline", now, time.Time{}); err != nil {
+			writeReply(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		area, err := areaParam(req.Context(), s, params, c)
+		if err != nil {
+			writeReply(w, err.Error(), areaStatus(err))
+			return
+		}
+		sched, err := s.CheapestSlots(req.Context(), o, area, c, ignoreMissingTariffs)
+		if errors.Is(err, power.ErrInvalidSlotOptions) {
+			writeReply(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, power.ErrNoSchedule) {
+			writeReply(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeReply(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		renderJson(w, sched)
+	}
+}
+
+// GetMeteringPointDetails is a handler to display details about the configured metering point
+func GetMeteringPointDetails(s *power.PriceService, c interfaces.Configurator) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		mp, err := s.MeteringPoint(req.Context(), c)
+		if err != nil {
+			writeReply(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		type Details struct {
+			MeteringPointId    string `json:"metering_point_id"`
+			Address            string `json:"address"`
+			MeterType          string `json:"meter_type"`
+			MeterNumber        string `json:"meter_number"`
+			SettlementMethod   string `json:"settlement_method"`
+			GridArea           string `json:"grid_area"`
+			GridOperator       string `json:"grid_operator"`
+			NetSettlementGroup string `json:"net_settlement_group"`
+			BalanceSupplier    string `json:"balance_supplier"`
+			PriceArea          string `json:"price_area,omitempty"`
+		}
+		area, _ := mp.PriceArea()
+		renderJson(w, Details{
+			MeteringPointId:    mp.MeteringPointId,
+			Address:            mp.Address(),
+			MeterType:          mp.MeterType(),
+			MeterNumber:        mp.MeterNumber,
+			SettlementMethod:   mp.Settlement(),
+			GridArea:           mp.MeteringGridAreaIdentification,
+			GridOperator:       mp.GridOperatorName,
+			NetSettlementGroup: mp.NetSettlementGroup,
+			BalanceSupplier:    mp.BalanceSupplierName,
+			PriceArea:          string(area),
+		})
+	}
+}
+
+// renderDelimited writes prices as values separated by comma, with the content
+// type contentType
+func renderDelimited(w http.ResponseWriter, req *http.Request, contentType string, prices []entities.FullPrice, comma rune) {
+	var buf bytes.Buffer
+	if err := format.Delimited(&buf, prices, comma); err != nil {
+		slog.ErrorContext(req.Context(), "error formatting prices", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	w.Write(output)
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
 }
 
-func getSpotPrices(noOfHours int) (energidataservice.Prices, error) {
-	var e energidataservice.EnergiDataService
-	e.Area(energidataservice.AreaDKEast)
-	e.Timer(time.Now(), time.Now().Add(time.Duration(noOfHours)*time.Hour))
-	p, err := e.Query()
+func renderJson(w http.ResponseWriter, data interface{}) {
+	output, err := json.Marshal(data)
 	if err != nil {
-		return energidataservice.Prices{}, err
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	return p.(energidataservice.Prices), nil
+	w.Write(output)
 }
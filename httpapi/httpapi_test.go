@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power"
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		accept  string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", url: "/powerPrices", want: formatJSON},
+		{name: "parameter", url: "/powerPrices?format=CSV", want: formatCSV},
+		{name: "parameter wins", url: "/powerPrices?format=tsv", accept: "text/csv", want: formatTSV},
+		{name: "unknown parameter", url: "/powerPrices?format=xml", wantErr: true},
+		{name: "accept", url: "/powerPrices", accept: "text/html, text/csv;q=0.9, */*;q=0.8", want: formatCSV},
+		{name: "accept tsv", url: "/powerPrices", accept: "text/tab-separated-values", want: formatTSV},
+		{name: "accept anything", url: "/powerPrices", accept: "*/*", want: formatJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			got, err := negotiate(req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetMeteringPointDetails(t *testing.T) {
+	up := fakeupstream.New(t)
+	s := power.NewPriceService(nil, nil, nil)
+	s.UseDetails(up.Details)
+
+	w := httptest.NewRecorder()
+	GetMeteringPointDetails(s, fakeupstream.Config{Key: fakeupstream.Token})(w, httptest.NewRequest("GET", "/meteringPoint", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var got map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, fakeupstream.MID, got["metering_point_id"])
+	assert.Equal(t, "DK1", got["price_area"])
+
+	// the configuration given is the one served
+	w = httptest.NewRecorder()
+	GetMeteringPointDetails(s, fakeupstream.Config{Key: "not-a-token"})(w, httptest.NewRequest("GET", "/meteringPoint", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestHandlers_ServiceClock(t *testing.T) {
+	up := fakeupstream.New(t)
+	now := time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location)
+	tariffs := func(c interfaces.Configurator) interfaces.TariffProvider { return up.Tariffs(c) }
+	s := power.NewPriceService(up.SpotPrices(), tariffs, power.ClockFunc(func() time.Time { return now }))
+	s.UseDetails(up.Details)
+	c := fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}
+
+	// prices start at the service's time
+	w := httptest.NewRecorder()
+	GetPowerPrices(s, c, false)(w, httptest.NewRequest("GET", "/powerPrices?hours=2", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var ps []entities.FullPrice
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ps))
+	require.Len(t, ps, 8)
+	assert.True(t, ps[0].ValidFrom.Equal(now))
+
+	// and so does the schedule, and deadlines are relative to it
+	w = httptest.NewRecorder()
+	GetCheapestSlots(s, c, false)(w, httptest.NewRequest("GET", "/cheapestSlots?duration=1h&deadline=18:00", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var sched power.Schedule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sched))
+	require.NotEmpty(t, sched.Slots)
+	assert.False(t, sched.Slots[0].From.Before(now))
+	assert.True(t, sched.Slots[len(sched.Slots)-1].To.Equal(now.Add(6*time.Hour)))
+}
@@ -0,0 +1,61 @@
+// Package influx writes line protocol to an InfluxDB v2 HTTP write endpoint
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/transport"
+)
+
+// Client writes to the bucket in org on the InfluxDB server at URL
+type Client struct {
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+	// HTTP is the client used for requests. Default is transport.Default.
+	HTTP *http.Client
+}
+
+// New returns a client for the InfluxDB configured in i
+func New(i config.Influx) (*Client, error) {
+	if i.URL == "" || i.Bucket == "" {
+		return nil, errors.New("influx url and bucket must be configured")
+	}
+	return &Client{URL: i.URL, Org: i.Org, Bucket: i.Bucket, Token: i.Token}, nil
+}
+
+// Write writes lines of line protocol, with timestamps in nanoseconds
+func (c *Client) Write(lines []byte) error {
+	q := url.Values{}
+	q.Set("org", c.Org)
+	q.Set("bucket", c.Bucket)
+	q.Set("precision", "ns")
+	u := strings.TrimSuffix(c.URL, "/") + "/api/v2/write?" + q.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	}
+	resp, err := transport.Client(c.HTTP).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// InfluxDB replies 204 on success
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influxdb returned %s, '%s'", resp.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package influx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adamhassel/power/entities/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Write(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, `{"code":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := New(config.Influx{URL: srv.URL + "/", Org: "home", Bucket: "power", Token: "secret"})
+	require.NoError(t, err)
+	require.NoError(t, c.Write([]byte("power_price total_inc_vat=1 1\n")))
+	assert.Equal(t, "/api/v2/write", got.URL.Path)
+	assert.Equal(t, "home", got.URL.Query().Get("org"))
+	assert.Equal(t, "power", got.URL.Query().Get("bucket"))
+	assert.Equal(t, "ns", got.URL.Query().Get("precision"))
+	assert.Equal(t, "power_price total_inc_vat=1 1\n", string(body))
+
+	c.Token = "wrong"
+	assert.Error(t, c.Write([]byte("power_price total_inc_vat=1 1\n")))
+
+	_, err = New(config.Influx{URL: srv.URL})
+	assert.Error(t, err)
+}
@@ -4,16 +4,12 @@
 package interfaces
 
 import (
+	"context"
 	"time"
 
 	"github.com/adamhassel/power/entities"
 )
 
-// Queryer will query in a well defined manner
-type Queryer interface {
-	Query() (interface{}, error)
-}
-
 // Authenticater allows configuration of authentication data
 type Authenticater interface {
 	Authenticate([]byte) error
@@ -45,7 +41,30 @@ type Pricer interface {
 	Price(time.Time) float64
 }
 
+// SpotPriceProvider provides spot prices in a price area, like DK1 or DK2,
+// from `from` to `to`. All prices have a DKK price, estimated from the EUR
+// price if there's none yet.
+type SpotPriceProvider interface {
+	SpotPrices(ctx context.Context, from, to time.Time, area string) ([]entities.Elspotprice, error)
+}
+
+// TariffProvider provides the tariffs for a metering point, in force from `from` to `to`
+type TariffProvider interface {
+	Tariffs(ctx context.Context, from, to time.Time) (entities.FullTariffs, error)
+}
+
+// ConsumptionProvider provides the metered consumption for a metering point from `from` to `to`
+type ConsumptionProvider interface {
+	Consumption(ctx context.Context, from, to time.Time) (entities.Consumptions, error)
+}
+
+// ExchangeRateProvider provides the exchange rate in DKK per unit of a currency at a given time
+type ExchangeRateProvider interface {
+	Rate(ctx context.Context, currency string, t time.Time) (float64, error)
+}
+
 type Configurator interface {
 	Token() string
 	MID() string
+	Area() string
 }
@@ -0,0 +1,26 @@
+// Package ctxtest provides contexts for tests.
+package ctxtest
+
+import (
+	"context"
+	"sync"
+)
+
+// waiting is a context calling waiting the first time Done is called
+type waiting struct {
+	context.Context
+	once    sync.Once
+	waiting func()
+}
+
+func (w *waiting) Done() <-chan struct{} {
+	w.once.Do(w.waiting)
+	return w.Context.Done()
+}
+
+// Waiting returns a context like ctx, which calls f the first time its Done
+// is called. Functions that select on Done once they're ready to wait, like
+// cache.Cache.Load, thereby tell when they're waiting.
+func Waiting(ctx context.Context, f func()) context.Context {
+	return &waiting{Context: ctx, waiting: f}
+}
@@ -0,0 +1,235 @@
+// Package fakeupstream stands in for energidataservice, eloverblik and
+// Nationalbanken in tests, serving canned responses in their formats from
+// testdata, so nothing needs network access. Point the repos at the URLs of
+// the servers, with their URL setters or DefaultURL, or use the providers from
+// SpotPrices, Tariffs and Rates, which do. Config configures the metering point
+// of the stand-ins. This package imports the repos, so their tests using it
+// must be in the external test packages.
+//
+// The responses have:
+//   - hourly spot prices (the elspotprices dataset) in DK1 and DK2 from Friday
+//     March 7th until Monday March 10th 2025. As on any weekend, there are only
+//     EUR prices on Saturday and Sunday.
+//   - 15 minute spot prices (the DayAheadPrices dataset) in DK1 and DK2 on
+//     Monday October 6th 2025, from before the prices of the next day were
+//     published.
+//   - the charges and details of the metering point MID, which is in DK1.
+//   - exchange rates from Nationalbanken for March 3rd to 7th 2025, and the
+//     current rates of October 6th 2025.
+package fakeupstream
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// Token is the eloverblik API token the stand-in accepts
+	Token = "fake-api-token"
+	// MID is the metering point of the charges and details
+	MID = "571313100000000000"
+)
+
+// Time formats of energidataservice, in queries and in records
+const (
+	queryTime  = "2006-01-02T15:04"
+	recordTime = "2006-01-02T15:04:05"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+// Upstream is a set of stand-ins, one server for each upstream
+type Upstream struct {
+	Energidataservice *httptest.Server
+	Eloverblik        *httptest.Server
+	Nationalbanken    *httptest.Server
+
+	mu sync.Mutex
+	// dataToken is the data access token eloverblik handed out last, and
+	// issued the number handed out
+	dataToken string
+	issued    int
+	requests  map[string]int
+	queries   map[string][]url.Values
+}
+
+// New starts the stand-ins, which are closed when t is done
+func New(t testing.TB) *Upstream {
+	u := &Upstream{requests: make(map[string]int), queries: make(map[string][]url.Values)}
+	u.Energidataservice = httptest.NewServer(u.count(u.spotPrices))
+	u.Eloverblik = httptest.NewServer(u.count(u.eloverblik))
+	u.Nationalbanken = httptest.NewServer(u.count(u.rates))
+	t.Cleanup(func() {
+		u.Energidataservice.Close()
+		u.Eloverblik.Close()
+		u.Nationalbanken.Close()
+	})
+	return u
+}
+
+// Requests returns the number of requests for path, like "/token" or
+// "/DayAheadPrices", to any of the stand-ins
+func (u *Upstream) Requests(path string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests[path]
+}
+
+// Queries returns the query parameters of the requests for path, in the
+// order they were made
+func (u *Upstream) Queries(path string) []url.Values {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]url.Values(nil), u.queries[path]...)
+}
+
+// Expire expires the data access token handed out by eloverblik, like it
+// does after a day, so requests with it are refused until a new one is fetched
+func (u *Upstream) Expire() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.dataToken = ""
+}
+
+// count counts the requests handled by h, and records their queries
+func (u *Upstream) count(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.requests[r.URL.Path]++
+		u.queries[r.URL.Path] = append(u.queries[r.URL.Path], r.URL.Query())
+		u.mu.Unlock()
+		h(w, r)
+	})
+}
+
+// spotPrices answers queries for the records of a dataset, like
+// energidataservice does: records in the area in the filter, from start up to
+// end in Danish time, a page of at most limit records from offset at a time
+func (u *Upstream) spotPrices(w http.ResponseWriter, r *http.Request) {
+	dataset := strings.TrimPrefix(r.URL.Path, "/")
+	raw, err := testdata.ReadFile("testdata/energidataservice/" + dataset + ".json")
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Dataset not found"})
+		return
+	}
+	var records []json.RawMessage
+	if err := json.Unmarshal(raw, &records); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	q := r.URL.Query()
+	start, err := time.Parse(queryTime, q.Get("start"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid start"})
+		return
+	}
+	end, err := time.Parse(queryTime, q.Get("end"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid end"})
+		return
+	}
+	var filter struct{ PriceArea string }
+	if f := q.Get("filter"); f != "" {
+		if err := json.Unmarshal([]byte(f), &filter); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid filter"})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	offset, _ := strconv.Atoi(q.Get("offset"))
+
+	var selected []json.RawMessage
+	for _, rec := range records {
+		// HourDK in elspotprices, TimeDK in DayAheadPrices
+		var fields struct{ HourDK, TimeDK, PriceArea string }
+		if err := json.Unmarshal(rec, &fields); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		dk, err := time.Parse(recordTime, fields.HourDK+fields.TimeDK)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if (filter.PriceArea == "" || filter.PriceArea == fields.PriceArea) && !dk.Before(start) && dk.Before(end) {
+			selected = append(selected, rec)
+		}
+	}
+	page := []json.RawMessage{}
+	if offset < len(selected) {
+		page = selected[offset:]
+		if limit > 0 && limit < len(page) {
+			page = page[:limit]
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total":   len(selected),
+		"filters": q.Get("filter"),
+		"dataset": dataset,
+		"records": page,
+	})
+}
+
+// eloverblik hands out data access tokens for the API token Token, and
+// answers requests for the charges and details of the metering point with
+// one of them
+func (u *Upstream) eloverblik(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/token":
+		if auth != "Bearer "+Token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		u.mu.Lock()
+		u.issued++
+		u.dataToken = fmt.Sprintf("fake-data-token-%d", u.issued)
+		token := u.dataToken
+		u.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]string{"result": token})
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/meteringpoints/meteringpoint/"):
+		u.mu.Lock()
+		valid := u.dataToken != "" && auth == "Bearer "+u.dataToken
+		u.mu.Unlock()
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		serve(w, "testdata/eloverblik/"+path.Base(r.URL.Path)+".json", "application/json")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// rates serves the exchange rates of Nationalbanken
+func (u *Upstream) rates(w http.ResponseWriter, r *http.Request) {
+	serve(w, "testdata/nationalbanken/"+path.Base(r.URL.Path)+".xml", "text/xml")
+}
+
+// serve writes the file name from testdata to w
+func serve(w http.ResponseWriter, name, contentType string) {
+	raw, err := testdata.ReadFile(name)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(raw)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
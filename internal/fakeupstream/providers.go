@@ -0,0 +1,57 @@
+package fakeupstream
+
+import (
+	"context"
+
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/eloverblik"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/repos/nationalbanken"
+)
+
+// Config is the configuration of the metering point MID, with the API token
+// Key, in the price area PriceArea, or none, so it's detected. It implements
+// interfaces.Configurator.
+type Config struct {
+	Key       string
+	PriceArea string
+}
+
+// Token implements interfaces.Configurator
+func (c Config) Token() string { return c.Key }
+
+// MID implements interfaces.Configurator
+func (Config) MID() string { return MID }
+
+// Area implements interfaces.Configurator
+func (c Config) Area() string { return c.PriceArea }
+
+// Rates returns a provider of exchange rates from the Nationalbanken stand-in
+func (u *Upstream) Rates() *nationalbanken.Nationalbanken {
+	var n nationalbanken.Nationalbanken
+	n.URL(u.Nationalbanken.URL)
+	return &n
+}
+
+// SpotPrices returns a provider of spot prices from the energidataservice
+// stand-in, estimating DKK prices with a provider from Rates
+func (u *Upstream) SpotPrices() *energidataservice.EnergiDataService {
+	var e energidataservice.EnergiDataService
+	e.URL(u.Energidataservice.URL)
+	e.Rates(u.Rates())
+	return &e
+}
+
+// Tariffs returns a provider of the tariffs of the metering point in c from
+// the eloverblik stand-in
+func (u *Upstream) Tariffs(c interfaces.Configurator) *eloverblik.Eloverblik {
+	e := eloverblik.FromConfig(c)
+	e.URL(u.Eloverblik.URL)
+	return e
+}
+
+// Details returns the details of the metering point in c from the eloverblik
+// stand-in
+func (u *Upstream) Details(ctx context.Context, c interfaces.Configurator) (eloverblik.MeteringPoint, error) {
+	return u.Tariffs(c).Details(ctx)
+}
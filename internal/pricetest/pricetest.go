@@ -0,0 +1,19 @@
+// Package pricetest provides prices for tests.
+package pricetest
+
+import (
+	"time"
+
+	"github.com/adamhassel/power/entities"
+)
+
+// Prices returns prices with the totals inc. VAT in totals, one after the
+// other from start, each lasting d
+func Prices(start time.Time, d time.Duration, totals ...float64) []entities.FullPrice {
+	rv := make([]entities.FullPrice, 0, len(totals))
+	for i, t := range totals {
+		from := start.Add(time.Duration(i) * d)
+		rv = append(rv, entities.FullPrice{ValidFrom: from, ValidTo: from.Add(d), TotalIncVAT: t})
+	}
+	return rv
+}
@@ -0,0 +1,163 @@
+// Package logging sets up structured, levelled logging with log/slog. Whatever
+// is logged goes through a Handler redacting credentials, so tokens never end
+// up in the logs, whatever the level.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces credentials in the logs
+const Redacted = "[REDACTED]"
+
+// sensitive are parts of keys, like header names, whose values are never logged
+var sensitive = []string{"authorization", "token", "password", "secret", "cookie"}
+
+// credentials matches credentials given in strings, like an Authorization
+// header value, and jsonCredentials those in JSON, like a request body
+var (
+	credentials     = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[^\s"',;]+`)
+	jsonCredentials = regexp.MustCompile(`(?i)("[^"]*(?:token|password|secret)[^"]*"\s*:\s*)"[^"]*"`)
+)
+
+// Setup makes the default logger log to stderr at level ("debug", "info",
+// "warn" or "error") in format ("text" or "json"). This includes what's
+// logged with the log package.
+func Setup(level, format string) error {
+	l, err := New(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+	return nil
+}
+
+// New returns a logger writing to w at level in format, redacting credentials
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text", "":
+		return slog.New(NewHandler(slog.NewTextHandler(w, opts))), nil
+	case "json":
+		return slog.New(NewHandler(slog.NewJSONHandler(w, opts))), nil
+	}
+	return nil, fmt.Errorf("unknown log format '%s'", format)
+}
+
+// Body is a request or response body to log, only made into a string if it's
+// logged. Log bodies at debug level.
+type Body []byte
+
+// LogValue implements slog.LogValuer
+func (b Body) LogValue() slog.Value {
+	return slog.StringValue(string(b))
+}
+
+// Handler is a slog.Handler redacting credentials from records, before
+// handing them to the handler it wraps. Values of attributes with sensitive
+// keys, like "Authorization" or "token", are replaced, and so are bearer and
+// basic credentials and sensitive JSON fields in the message and in strings
+// and errors. http.Header values are logged as groups, so they're redacted by
+// name.
+type Handler struct {
+	next slog.Handler
+}
+
+// NewHandler returns a Handler wrapping next
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+// Enabled implements slog.Handler
+func (h *Handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+// Handle implements slog.Handler
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+// WithAttrs implements slog.Handler
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redact(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted)}
+}
+
+// WithGroup implements slog.Handler
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name)}
+}
+
+// redact returns a without credentials
+func redact(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = redact(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case http.Header:
+			return redact(headers(a.Key, x))
+		case error:
+			return slog.String(a.Key, redactString(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, redactString(x.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// headers returns h as a group of its headers
+func headers(key string, h http.Header) slog.Attr {
+	attrs := make([]slog.Attr, 0, len(h))
+	for name, vs := range h {
+		attrs = append(attrs, slog.String(name, strings.Join(vs, ", ")))
+	}
+	return slog.Attr{Key: key, Value: slog.GroupValue(attrs...)}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitive {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactString replaces the credentials in s
+func redactString(s string) string {
+	s = credentials.ReplaceAllString(s, "$1 "+Redacted)
+	return jsonCredentials.ReplaceAllString(s, `$1"`+Redacted+`"`)
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "eyJhbGciOiJIUzI1NiJ9.c2VjcmV0.dG9rZW4"
+
+func TestHandler_Redacts(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, "debug", "json")
+	require.NoError(t, err)
+
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+secret)
+	h.Set("Content-Type", "application/json")
+	l = l.With("token", secret)
+	l.Debug("request with Bearer "+secret,
+		"headers", h,
+		"body", `{"mid":"571313115100000000","access_token": "`+secret+`"}`,
+		"err", errors.New("failed: Authorization: Bearer "+secret),
+		slog.Group("auth", "refresh_token", secret),
+	)
+
+	out := buf.String()
+	assert.NotContains(t, out, secret)
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "request with Bearer "+Redacted, rec["msg"])
+	assert.Equal(t, Redacted, rec["token"])
+	assert.Equal(t, map[string]any{"Authorization": Redacted, "Content-Type": "application/json"}, rec["headers"])
+	assert.Equal(t, "failed: Authorization: Bearer "+Redacted, rec["err"])
+	assert.Equal(t, map[string]any{"refresh_token": Redacted}, rec["auth"])
+	assert.Equal(t, `{"mid":"571313115100000000","access_token": "`+Redacted+`"}`, rec["body"])
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, "WARN", "text")
+	require.NoError(t, err)
+	l.Info("hidden")
+	l.Warn("shown", "n", 1)
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=WARN msg=shown n=1")
+
+	_, err = New(&buf, "loud", "text")
+	assert.Error(t, err)
+	_, err = New(&buf, "info", "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+// Package metrics exports prices and the health of the upstream services as
+// Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "power"
+
+// Upstream services
+const (
+	Energidataservice = "energidataservice"
+	Eloverblik        = "eloverblik"
+	Nationalbanken    = "nationalbanken"
+)
+
+var (
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Duration of requests to upstream services.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"upstream"})
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Number of failed requests to upstream services.",
+	}, []string{"upstream"})
+)
+
+func init() {
+	prometheus.MustRegister(upstreamDuration, upstreamErrors)
+}
+
+// ObserveUpstream records a request to upstream, started at start. It's meant
+// to be deferred, with err pointing at the error returned by the request.
+func ObserveUpstream(upstream string, start time.Time, err *error) {
+	upstreamDuration.WithLabelValues(upstream).Observe(time.Since(start).Seconds())
+	if err != nil && *err != nil {
+		upstreamErrors.WithLabelValues(upstream).Inc()
+	}
+}
+
+var (
+	labels        = []string{"area"}
+	totalDesc     = prometheus.NewDesc(namespace+"_price_total_inc_vat", "Current price per kWh, including VAT.", labels, nil)
+	totalExDesc   = prometheus.NewDesc(namespace+"_price_total_ex_vat", "Current price per kWh, excluding VAT.", labels, nil)
+	spotDesc      = prometheus.NewDesc(namespace+"_price_spot_ex_vat", "Current spot price per kWh, excluding VAT.", labels, nil)
+	taxDesc       = prometheus.NewDesc(namespace+"_price_tax_ex_vat", "Current taxes and tariffs per kWh, excluding VAT.", []string{"area", "tax"}, nil)
+	estimatedDesc = prometheus.NewDesc(namespace+"_price_estimated", "1 if the current spot price is converted from EUR with an estimated exchange rate.", labels, nil)
+	nextDesc      = prometheus.NewDesc(namespace+"_price_next_total_inc_vat", "Price per kWh in the next period, including VAT.", labels, nil)
+	ageDesc       = prometheus.NewDesc(namespace+"_cache_age_seconds", "Time since cached data was last fetched.", []string{"cache"}, nil)
+)
+
+// collectTimeout is how long Collect waits for prices, unless another is set in
+// PriceCollector.Timeout. It's below the default scrape timeout of Prometheus,
+// 10 seconds, so a slow upstream doesn't fail the scrape.
+const collectTimeout = 8 * time.Second
+
+// PriceCollector collects the current prices in an area, and the age of the
+// cached prices and tariffs
+type PriceCollector struct {
+	Area string
+	// Prices returns the prices from `from` to `to`
+	Prices entities.PriceSource
+	// PricesUpdated and TariffsUpdated return when the caches were last updated
+	PricesUpdated  func() time.Time
+	TariffsUpdated func() time.Time
+	// Now returns the current time. Default is time.Now.
+	Now func() time.Time
+	// Timeout is how long to wait for prices. Default is 8 seconds.
+	Timeout time.Duration
+}
+
+// Describe implements prometheus.Collector
+func (pc PriceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{totalDesc, totalExDesc, spotDesc, taxDesc, estimatedDesc, nextDesc, ageDesc} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (pc PriceCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	if pc.Now != nil {
+		now = pc.Now()
+	}
+	// fetch from the start of the hour, so the cache is hit, and until the end
+	// of the next hour. Without prices, the other metrics are still collected,
+	// so a failing upstream shows in them.
+	timeout := pc.Timeout
+	if timeout <= 0 {
+		timeout = collectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	prices, err := pc.Prices(ctx, now.Truncate(time.Hour), now.Truncate(time.Hour).Add(2*time.Hour))
+	if err != nil {
+		slog.Error("error getting prices for metrics", "err", err)
+	}
+	for i, p := range prices {
+		if now.Before(p.ValidFrom) || !now.Before(p.ValidTo) {
+			continue
+		}
+		gauge := func(d *prometheus.Desc, v float64, lv ...string) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, append([]string{pc.Area}, lv...)...)
+		}
+		gauge(totalDesc, p.TotalIncVAT)
+		gauge(totalExDesc, p.Total)
+		gauge(spotDesc, p.RawPrice)
+		// tariffs from different owners may share a name
+		taxes := make(map[string]float64)
+		for _, t := range p.Taxes {
+			taxes[t.Name] += t.Amount
+		}
+		for name, amount := range taxes {
+			gauge(taxDesc, amount, name)
+		}
+		var estimated float64
+		if p.Estimated {
+			estimated = 1
+		}
+		gauge(estimatedDesc, estimated)
+		if i+1 < len(prices) {
+			gauge(nextDesc, prices[i+1].TotalIncVAT)
+		}
+		break
+	}
+	age := func(cache string, updated func() time.Time) {
+		if updated == nil || updated().IsZero() {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(ageDesc, prometheus.GaugeValue, now.Sub(updated()).Seconds(), cache)
+	}
+	age("prices", pc.PricesUpdated)
+	age("tariffs", pc.TariffsUpdated)
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceCollector(t *testing.T) {
+	start := time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location)
+	now := start.Add(59 * time.Minute)
+	pc := PriceCollector{
+		Area: "DK2",
+		Now:  func() time.Time { return now },
+		Prices: func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+			return []entities.FullPrice{
+				{
+					ValidFrom: start, ValidTo: start.Add(time.Hour),
+					Taxes:    []entities.Tax{{Name: "Elafgift", Amount: 0.7}, {Name: "Nettarif", Amount: 0.2}, {Name: "Nettarif", Amount: 0.1}},
+					RawPrice: 1, Total: 2, TotalIncVAT: 2.5, Estimated: true,
+				},
+				{ValidFrom: start.Add(time.Hour), ValidTo: start.Add(2 * time.Hour), TotalIncVAT: 3},
+			}, nil
+		},
+		PricesUpdated: func() time.Time { return now.Add(-time.Minute) },
+	}
+	expected := `
+# HELP power_price_total_inc_vat Current price per kWh, including VAT.
+# TYPE power_price_total_inc_vat gauge
+power_price_total_inc_vat{area="DK2"} 2.5
+# HELP power_price_spot_ex_vat Current spot price per kWh, excluding VAT.
+# TYPE power_price_spot_ex_vat gauge
+power_price_spot_ex_vat{area="DK2"} 1
+# HELP power_price_tax_ex_vat Current taxes and tariffs per kWh, excluding VAT.
+# TYPE power_price_tax_ex_vat gauge
+power_price_tax_ex_vat{area="DK2",tax="Elafgift"} 0.7
+power_price_tax_ex_vat{area="DK2",tax="Nettarif"} 0.30000000000000004
+# HELP power_price_estimated 1 if the current spot price is converted from EUR with an estimated exchange rate.
+# TYPE power_price_estimated gauge
+power_price_estimated{area="DK2"} 1
+# HELP power_price_next_total_inc_vat Price per kWh in the next period, including VAT.
+# TYPE power_price_next_total_inc_vat gauge
+power_price_next_total_inc_vat{area="DK2"} 3
+`
+	require.NoError(t, testutil.CollectAndCompare(pc, strings.NewReader(expected),
+		"power_price_total_inc_vat", "power_price_spot_ex_vat", "power_price_tax_ex_vat", "power_price_estimated", "power_price_next_total_inc_vat"))
+	// total inc and ex VAT, spot, two taxes, estimated, next, and the age of the price cache
+	assert.Equal(t, 8, testutil.CollectAndCount(pc))
+
+	pc.Prices = func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+		return nil, errors.New("no prices")
+	}
+	// the age of the cache is still there, without the prices
+	require.NoError(t, testutil.CollectAndCompare(pc, strings.NewReader(`
+# HELP power_cache_age_seconds Time since cached data was last fetched.
+# TYPE power_cache_age_seconds gauge
+power_cache_age_seconds{cache="prices"} 60
+`)))
+	// and scraping doesn't fail, so upstream errors are exported too
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(pc, upstreamErrors)
+	upstreamErrors.WithLabelValues(Energidataservice).Inc()
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	assert.Contains(t, names, "power_upstream_errors_total")
+}
+
+func TestPriceCollector_SlowUpstream(t *testing.T) {
+	now := time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location)
+	release := make(chan struct{})
+	defer close(release)
+	pc := PriceCollector{
+		Area: "DK2",
+		Now:  func() time.Time { return now },
+		// like a load that keeps going for others, but gives up with ctx
+		Prices: func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+			select {
+			case <-release:
+				return nil, errors.New("too late")
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+		PricesUpdated: func() time.Time { return now.Add(-time.Minute) },
+		Timeout:       50 * time.Millisecond,
+	}
+	start := time.Now()
+	// the scrape isn't blocked, and still has the age of the cache
+	assert.Equal(t, 1, testutil.CollectAndCount(pc))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestObserveUpstream(t *testing.T) {
+	before := testutil.ToFloat64(upstreamErrors.WithLabelValues(Eloverblik))
+	func() (err error) {
+		defer ObserveUpstream(Eloverblik, time.Now(), &err)
+		return errors.New("failed")
+	}()
+	func() (err error) {
+		defer ObserveUpstream(Eloverblik, time.Now(), &err)
+		return nil
+	}()
+	assert.Equal(t, before+1, testutil.ToFloat64(upstreamErrors.WithLabelValues(Eloverblik)))
+}
@@ -0,0 +1,277 @@
+// Package mqtt publishes prices to an MQTT broker, along with Home Assistant
+// discovery config, so the prices show up as sensors.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adamhassel/power"
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/entities/config"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultPrefix          = "power"
+	defaultDiscoveryPrefix = "homeassistant"
+	// timeout is how long to wait for the broker to acknowledge a publish
+	timeout = 10 * time.Second
+)
+
+// Publisher publishes the prices in a price area. All messages are retained,
+// under <prefix>/<area>/:
+//
+//	current     the current price inc. VAT
+//	next        the price inc. VAT in the next period
+//	today       today's prices, as JSON
+//	tomorrow    tomorrow's prices as JSON, empty until they're published
+//	attributes  today's and tomorrow's prices inc. VAT, for Home Assistant
+type Publisher struct {
+	client    paho.Client
+	prefix    string
+	discovery string
+	area      string
+	poll      time.Duration
+	// announced is set once New has announced the sensors, so reconnects announce them again
+	announced atomic.Bool
+}
+
+// New connects to the broker configured in c, to publish prices in area, and
+// announces the sensors: it publishes that it's online, and the Home Assistant
+// discovery config. It does so again on every reconnect, as the broker will
+// have published the "offline" will in the meantime.
+func New(c config.MQTT, area string) (*Publisher, error) {
+	if c.Broker == "" {
+		return nil, errors.New("no mqtt broker configured")
+	}
+	p := &Publisher{prefix: c.Prefix, discovery: c.DiscoveryPrefix, area: area, poll: c.PollInterval()}
+	if p.prefix == "" {
+		p.prefix = defaultPrefix
+	}
+	if p.discovery == "" {
+		p.discovery = defaultDiscoveryPrefix
+	}
+	clientID := c.ClientID
+	if clientID == "" {
+		clientID = "power-" + strings.ToLower(area)
+	}
+	opts := paho.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(clientID).
+		SetUsername(c.Username).
+		SetPassword(c.Password).
+		SetAutoReconnect(true).
+		// let Home Assistant know when we're gone
+		SetWill(p.topic("status"), "offline", 1, true).
+		SetOnConnectHandler(func(paho.Client) {
+			if !p.announced.Load() {
+				return
+			}
+			if err := p.announce(); err != nil {
+				slog.Error("error announcing mqtt sensors after reconnect", "err", err)
+			}
+		})
+	p.client = paho.NewClient(opts)
+	if err := wait(p.client.Connect()); err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", c.Broker, err)
+	}
+	if err := p.announce(); err != nil {
+		p.client.Disconnect(250)
+		return nil, err
+	}
+	p.announced.Store(true)
+	return p, nil
+}
+
+// announce publishes that we're online, and the discovery config
+func (p *Publisher) announce() error {
+	if err := p.publish("status", "online"); err != nil {
+		return err
+	}
+	return p.publishDiscovery()
+}
+
+// Close disconnects from the broker
+func (p *Publisher) Close() {
+	p.publish("status", "offline")
+	p.client.Disconnect(250)
+}
+
+// discoveryConfig is the Home Assistant MQTT discovery config of a sensor
+type discoveryConfig struct {
+	Name                string `json:"name"`
+	UniqueID            string `json:"unique_id"`
+	StateTopic          string `json:"state_topic"`
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"`
+	AvailabilityTopic   string `json:"availability_topic"`
+	UnitOfMeasurement   string `json:"unit_of_measurement"`
+	StateClass          string `json:"state_class"`
+	Icon                string `json:"icon"`
+	Device              struct {
+		Identifiers []string `json:"identifiers"`
+		Name        string   `json:"name"`
+	} `json:"device"`
+}
+
+// publishDiscovery publishes Home Assistant discovery config for sensors with
+// the current and the next price
+func (p *Publisher) publishDiscovery() error {
+	id := strings.ToLower(p.prefix + "_" + p.area)
+	for _, s := range []struct{ topic, name, attributes string }{
+		{"current", "Power price", p.topic("attributes")},
+		{"next", "Next power price", ""},
+	} {
+		dc := discoveryConfig{
+			Name:                s.name,
+			UniqueID:            id + "_" + s.topic,
+			StateTopic:          p.topic(s.topic),
+			JSONAttributesTopic: s.attributes,
+			AvailabilityTopic:   p.topic("status"),
+			UnitOfMeasurement:   "DKK/kWh",
+			StateClass:          "measurement",
+			Icon:                "mdi:flash",
+		}
+		dc.Device.Identifiers = []string{id}
+		dc.Device.Name = "Power prices " + p.area
+		b, err := json.Marshal(dc)
+		if err != nil {
+			return err
+		}
+		if err := p.publishRaw(fmt.Sprintf("%s/sensor/%s_%s/config", p.discovery, id, s.topic), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attribute is a price in the attributes topic
+type attribute struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Value float64   `json:"value"`
+}
+
+// Publish publishes the prices as of now
+func (p *Publisher) Publish(prices []entities.FullPrice, now time.Time) error {
+	fp := power.FullPrices{Contents: prices}
+	today := dayStart(now)
+	todays := fp.Range(today, today.AddDate(0, 0, 1)).Contents
+	tomorrows := fp.Range(today.AddDate(0, 0, 1), today.AddDate(0, 0, 2)).Contents
+
+	if cur, ok := fp.At(now); ok {
+		if err := p.publish("current", price(cur)); err != nil {
+			return err
+		}
+		if next, ok := fp.At(cur.ValidTo); ok {
+			if err := p.publish("next", price(next)); err != nil {
+				return err
+			}
+		}
+	}
+	attrs := struct {
+		Today         []attribute `json:"today"`
+		Tomorrow      []attribute `json:"tomorrow"`
+		TomorrowValid bool        `json:"tomorrow_valid"`
+	}{attributes(todays), attributes(tomorrows), len(tomorrows) > 0}
+	for topic, v := range map[string]interface{}{"today": todays, "tomorrow": tomorrows, "attributes": attrs} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := p.publishRaw(p.topic(topic), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run fetches prices from src and publishes them at every price period
+// boundary, and when tomorrow's prices are published, until ctx is done.
+// Until then, it checks for tomorrow's prices every poll interval.
+func (p *Publisher) Run(ctx context.Context, src entities.PriceSource) {
+	var period time.Time // start of the period last published
+	var known int        // number of tomorrow's prices last published
+	for {
+		now := time.Now()
+		today := dayStart(now)
+		prices, err := src(ctx, today, today.AddDate(0, 0, 2))
+		if ctx.Err() != nil {
+			return
+		}
+		fp := power.FullPrices{Contents: prices}
+		cur, _ := fp.At(now)
+		if err != nil {
+			slog.Error("error fetching prices for mqtt", "err", err)
+		} else if n := len(fp.Range(today.AddDate(0, 0, 1), today.AddDate(0, 0, 2)).Contents); !cur.ValidFrom.Equal(period) || n != known {
+			if err := p.Publish(prices, now); err != nil {
+				slog.Error("error publishing prices to mqtt", "err", err)
+			} else {
+				period, known = cur.ValidFrom, n
+			}
+		}
+
+		next := now.Truncate(time.Hour).Add(time.Hour)
+		if !cur.ValidTo.IsZero() {
+			next = cur.ValidTo
+		}
+		wait := next.Sub(now)
+		if known == 0 && p.poll < wait {
+			wait = p.poll
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// topic returns the topic named by parts, under the prefix and area
+func (p *Publisher) topic(parts ...string) string {
+	return strings.Join(append([]string{p.prefix, p.area}, parts...), "/")
+}
+
+// publish publishes s as a retained message to the topic under the prefix and area
+func (p *Publisher) publish(topic, s string) error {
+	return p.publishRaw(p.topic(topic), []byte(s))
+}
+
+func (p *Publisher) publishRaw(topic string, payload []byte) error {
+	if err := wait(p.client.Publish(topic, 1, true, payload)); err != nil {
+		return fmt.Errorf("publishing to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// wait waits for t to complete, returning its error
+func wait(t paho.Token) error {
+	if !t.WaitTimeout(timeout) {
+		return errors.New("timed out waiting for mqtt broker")
+	}
+	return t.Error()
+}
+
+func price(p entities.FullPrice) string {
+	return fmt.Sprintf("%.4f", p.TotalIncVAT)
+}
+
+func attributes(ps []entities.FullPrice) []attribute {
+	rv := make([]attribute, len(ps))
+	for i, p := range ps {
+		rv[i] = attribute{Start: p.ValidFrom, End: p.ValidTo, Value: p.TotalIncVAT}
+	}
+	return rv
+}
+
+// dayStart returns the start of the day containing t, in Danish time
+func dayStart(t time.Time) time.Time {
+	t = t.In(entities.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, entities.Location)
+}
@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/internal/pricetest"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// broker starts an embedded broker, and returns it, its address and the retained messages by topic
+func broker(t *testing.T) (*mochi.Server, string, func() map[string]string) {
+	srv := mochi.New(&mochi.Options{InlineClient: true})
+	require.NoError(t, srv.AddHook(new(auth.AllowHook), nil))
+	l := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	require.NoError(t, srv.AddListener(l))
+	go srv.Serve()
+	t.Cleanup(func() { srv.Close() })
+
+	var mu sync.Mutex
+	msgs := make(map[string]string)
+	require.NoError(t, srv.Subscribe("#", 1, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		mu.Lock()
+		defer mu.Unlock()
+		msgs[pk.TopicName] = string(pk.Payload)
+	}))
+	return srv, "tcp://" + l.Address(), func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		rv := make(map[string]string, len(msgs))
+		for k, v := range msgs {
+			rv[k] = v
+		}
+		return rv
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	_, addr, messages := broker(t)
+	p, err := New(config.MQTT{Broker: addr}, "DK2")
+	require.NoError(t, err)
+	defer p.Close()
+
+	today := time.Date(2025, 10, 2, 0, 0, 0, 0, entities.Location)
+	totals := make([]float64, 96)
+	for i := range totals {
+		totals[i] = float64(i) / 10
+	}
+	now := today.Add(10*time.Hour + 20*time.Minute)
+	require.NoError(t, p.Publish(pricetest.Prices(today, 15*time.Minute, totals...), now))
+
+	var msgs map[string]string
+	require.Eventually(t, func() bool {
+		msgs = messages()
+		return msgs["power/DK2/attributes"] != ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "online", msgs["power/DK2/status"])
+	assert.Equal(t, "4.1000", msgs["power/DK2/current"])
+	assert.Equal(t, "4.2000", msgs["power/DK2/next"])
+	assert.Equal(t, "[]", msgs["power/DK2/tomorrow"])
+	var todays []entities.FullPrice
+	require.NoError(t, json.Unmarshal([]byte(msgs["power/DK2/today"]), &todays))
+	assert.Len(t, todays, 96)
+
+	var dc discoveryConfig
+	require.NoError(t, json.Unmarshal([]byte(msgs["homeassistant/sensor/power_dk2_current/config"]), &dc))
+	assert.Equal(t, "power/DK2/current", dc.StateTopic)
+	assert.Equal(t, "power/DK2/attributes", dc.JSONAttributesTopic)
+	assert.Equal(t, "power_dk2_current", dc.UniqueID)
+	assert.Contains(t, msgs, "homeassistant/sensor/power_dk2_next/config")
+}
+
+func TestPublisher_Reconnect(t *testing.T) {
+	srv, addr, messages := broker(t)
+	p, err := New(config.MQTT{Broker: addr, ClientID: "reconnect"}, "DK1")
+	require.NoError(t, err)
+	defer p.Close()
+
+	var mu sync.Mutex
+	var statuses []string
+	require.NoError(t, srv.Subscribe("power/DK1/status", 2, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses = append(statuses, string(pk.Payload))
+	}))
+
+	// drop the connection, so the broker publishes the will, and we're back
+	// online once reconnected
+	cl, ok := srv.Clients.Get("reconnect")
+	require.True(t, ok)
+	cl.Stop(errors.New("dropped"))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		n := len(statuses)
+		return n > 1 && statuses[n-2] == "offline" && statuses[n-1] == "online"
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Contains(t, messages(), "homeassistant/sensor/power_dk1_current/config")
+}
+
+func TestPublisher_Run(t *testing.T) {
+	_, addr, messages := broker(t)
+	p, err := New(config.MQTT{Broker: addr, Prefix: "prices", Poll: "20ms"}, "DK1")
+	require.NoError(t, err)
+	defer p.Close()
+
+	// tomorrow's prices show up on the second fetch
+	var mu sync.Mutex
+	var fetches int
+	src := func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		n := 96
+		if fetches > 1 {
+			n = 192
+		}
+		return pricetest.Prices(from, 15*time.Minute, make([]float64, n)...), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, src)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool {
+		var tomorrow []entities.FullPrice
+		json.Unmarshal([]byte(messages()["prices/DK1/tomorrow"]), &tomorrow)
+		return len(tomorrow) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
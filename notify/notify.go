@@ -0,0 +1,203 @@
+// Package notify watches prices, and sends notifications when they cross
+// thresholds, or when tomorrow's prices are published.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/adamhassel/errors"
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/transport"
+)
+
+// Kind is the kind of an event
+type Kind string
+
+const (
+	// KindBelow is when the price drops below the lower threshold
+	KindBelow Kind = "below"
+	// KindAbove is when the price rises above the upper threshold
+	KindAbove Kind = "above"
+	// KindTomorrow is when tomorrow's prices are published
+	KindTomorrow Kind = "tomorrow"
+)
+
+// Event is something to notify about. Price is the current price for KindBelow
+// and KindAbove, and Prices are tomorrow's prices for KindTomorrow.
+type Event struct {
+	Kind      Kind                 `json:"kind"`
+	At        time.Time            `json:"at"`
+	Threshold float64              `json:"threshold,omitempty"`
+	Price     *entities.FullPrice  `json:"price,omitempty"`
+	Prices    []entities.FullPrice `json:"prices,omitempty"`
+}
+
+// Title is a short summary of e
+func (e Event) Title() string {
+	switch e.Kind {
+	case KindBelow:
+		return fmt.Sprintf("Power price below %.2f", e.Threshold)
+	case KindAbove:
+		return fmt.Sprintf("Power price above %.2f", e.Threshold)
+	case KindTomorrow:
+		return "Tomorrow's power prices are ready"
+	}
+	return string(e.Kind)
+}
+
+// Message is a human readable description of e
+func (e Event) Message() string {
+	if e.Kind == KindTomorrow {
+		if len(e.Prices) == 0 {
+			return "No prices"
+		}
+		min, max, sum := e.Prices[0], e.Prices[0], 0.0
+		for _, p := range e.Prices {
+			if p.TotalIncVAT < min.TotalIncVAT {
+				min = p
+			}
+			if p.TotalIncVAT > max.TotalIncVAT {
+				max = p
+			}
+			sum += p.TotalIncVAT
+		}
+		return fmt.Sprintf("Lowest %.2f at %s, highest %.2f at %s, average %.2f kr/kWh inc. VAT",
+			min.TotalIncVAT, min.ValidFrom.In(entities.Location).Format("15:04"),
+			max.TotalIncVAT, max.ValidFrom.In(entities.Location).Format("15:04"),
+			sum/float64(len(e.Prices)))
+	}
+	if e.Price == nil {
+		return e.Title()
+	}
+	return fmt.Sprintf("%.2f kr/kWh inc. VAT from %s to %s",
+		e.Price.TotalIncVAT, e.Price.ValidFrom.In(entities.Location).Format("15:04"), e.Price.ValidTo.In(entities.Location).Format("15:04"))
+}
+
+// Sink is somewhere to send notifications
+type Sink interface {
+	Notify(e Event) error
+}
+
+// Watcher checks prices against thresholds, and notifies its sinks when they
+// are crossed. Threshold events are only sent when the price crosses the
+// threshold, not for every check while it stays on the other side.
+type Watcher struct {
+	// Below and Above are the thresholds for TotalIncVAT. nil disables them.
+	Below, Above *float64
+	// Tomorrow enables notifications when tomorrow's prices are published
+	Tomorrow bool
+	Sinks    []Sink
+
+	below, above bool
+	tomorrow     string // the date tomorrow's prices were last notified for
+}
+
+// Check checks prices as of now, and notifies the sinks of any events
+func (w *Watcher) Check(prices []entities.FullPrice, now time.Time) error {
+	var events []Event
+	if cur, ok := current(prices, now); ok {
+		below := w.Below != nil && cur.TotalIncVAT < *w.Below
+		if below && !w.below {
+			events = append(events, Event{Kind: KindBelow, At: now, Threshold: *w.Below, Price: &cur})
+		}
+		above := w.Above != nil && cur.TotalIncVAT > *w.Above
+		if above && !w.above {
+			events = append(events, Event{Kind: KindAbove, At: now, Threshold: *w.Above, Price: &cur})
+		}
+		w.below, w.above = below, above
+	}
+	if w.Tomorrow {
+		local := now.In(entities.Location)
+		start := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, entities.Location)
+		date := start.Format("2006-01-02")
+		if ps := between(prices, start, start.AddDate(0, 0, 1)); len(ps) > 0 && w.tomorrow != date {
+			events = append(events, Event{Kind: KindTomorrow, At: now, Prices: ps})
+			w.tomorrow = date
+		}
+	}
+	return w.notify(events)
+}
+
+// notify sends events to all sinks, returning all errors encountered
+func (w *Watcher) notify(events []Event) error {
+	var errs []error
+	for _, e := range events {
+		for _, s := range w.Sinks {
+			if err := s.Notify(e); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.Wrap(errs...)
+}
+
+// Run fetches prices from src every interval, and checks them, until ctx is done
+func (w *Watcher) Run(ctx context.Context, src entities.PriceSource, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		now := time.Now()
+		local := now.In(entities.Location)
+		from := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, entities.Location)
+		prices, err := src(ctx, from, from.AddDate(0, 0, 2))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("error fetching prices for notifications", "err", err)
+		} else if err := w.Check(prices, now); err != nil {
+			slog.Error("error sending notifications", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// current returns the price in force at t
+func current(prices []entities.FullPrice, t time.Time) (entities.FullPrice, bool) {
+	for _, p := range prices {
+		if !t.Before(p.ValidFrom) && t.Before(p.ValidTo) {
+			return p, true
+		}
+	}
+	return entities.FullPrice{}, false
+}
+
+// between returns the prices starting from `from` and before `to`
+func between(prices []entities.FullPrice, from, to time.Time) []entities.FullPrice {
+	var rv []entities.FullPrice
+	for _, p := range prices {
+		if !p.ValidFrom.Before(from) && p.ValidFrom.Before(to) {
+			rv = append(rv, p)
+		}
+	}
+	return rv
+}
+
+// FromConfig returns a watcher with the thresholds and sinks configured in n.
+// The HTTP sinks use the timeout and circuit breaker in o, but never retry.
+func FromConfig(n config.Notify, o transport.Options) *Watcher {
+	w := &Watcher{Below: n.Below, Above: n.Above, Tomorrow: n.Tomorrow}
+	client := noRetryClient(o)
+	if n.Webhook != "" {
+		w.Sinks = append(w.Sinks, Webhook{URL: n.Webhook, Client: client})
+	}
+	if n.Ntfy != "" {
+		w.Sinks = append(w.Sinks, Ntfy{URL: n.Ntfy, Token: n.NtfyToken, Client: client})
+	}
+	if n.SMTP.Addr != "" {
+		s := n.SMTP
+		w.Sinks = append(w.Sinks, SMTP{Addr: s.Addr, From: s.From, To: s.To, Username: s.Username, Password: s.Password})
+	}
+	return w
+}
@@ -0,0 +1,225 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/internal/pricetest"
+	"github.com/adamhassel/power/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a sink remembering the events it's notified of
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Notify(e Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestWatcher_Thresholds(t *testing.T) {
+	below, above := 1.0, 3.0
+	r := &recorder{}
+	w := Watcher{Below: &below, Above: &above, Sinks: []Sink{r}}
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	prices := pricetest.Prices(start, time.Hour, 2, 0.5, 0.7, 2, 3.5, 4, 0.9)
+
+	var kinds []Kind
+	for i := range prices {
+		r.events = nil
+		require.NoError(t, w.Check(prices, start.Add(time.Duration(i)*time.Hour+time.Minute)))
+		for _, e := range r.events {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	// only crossings are notified
+	assert.Equal(t, []Kind{KindBelow, KindAbove, KindBelow}, kinds)
+	require.Len(t, r.events, 1)
+	assert.Equal(t, 0.9, r.events[0].Price.TotalIncVAT)
+	assert.Equal(t, below, r.events[0].Threshold)
+}
+
+func TestWatcher_Tomorrow(t *testing.T) {
+	r := &recorder{}
+	w := Watcher{Tomorrow: true, Sinks: []Sink{r}}
+	today := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	now := today.Add(12 * time.Hour)
+
+	require.NoError(t, w.Check(pricetest.Prices(today, time.Hour, make([]float64, 24)...), now))
+	assert.Empty(t, r.events)
+
+	prices := pricetest.Prices(today, time.Hour, make([]float64, 48)...)
+	require.NoError(t, w.Check(prices, now))
+	require.Len(t, r.events, 1)
+	assert.Equal(t, KindTomorrow, r.events[0].Kind)
+	assert.Len(t, r.events[0].Prices, 24)
+	assert.Equal(t, today.AddDate(0, 0, 1), r.events[0].Prices[0].ValidFrom)
+
+	// only once per day
+	require.NoError(t, w.Check(prices, now.Add(time.Hour)))
+	assert.Len(t, r.events, 1)
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	p := pricetest.Prices(time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location), time.Hour, 0.5)[0]
+	e := Event{Kind: KindBelow, At: p.ValidFrom, Threshold: 1, Price: &p}
+	require.NoError(t, Webhook{URL: srv.URL}.Notify(e))
+	assert.Equal(t, KindBelow, got.Kind)
+	assert.Equal(t, 0.5, got.Price.TotalIncVAT)
+
+	// a failed POST isn't retried, as it may have been delivered anyway
+	var posts int
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		http.Error(w, "nope", http.StatusInternalServerError)
+	})
+	assert.Error(t, Webhook{URL: srv.URL}.Notify(e))
+	assert.Equal(t, 1, posts)
+}
+
+func TestNtfy_Notify(t *testing.T) {
+	var body string
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, header = string(b), r.Header
+	}))
+	defer srv.Close()
+
+	p := pricetest.Prices(time.Date(2022, 2, 1, 17, 0, 0, 0, entities.Location), time.Hour, 4.2)[0]
+	e := Event{Kind: KindAbove, At: p.ValidFrom, Threshold: 3, Price: &p}
+	require.NoError(t, Ntfy{URL: srv.URL + "/power", Token: "secret"}.Notify(e))
+	assert.Equal(t, "4.20 kr/kWh inc. VAT from 17:00 to 18:00", body)
+	assert.Equal(t, "Power price above 3.00", header.Get("Title"))
+	assert.Equal(t, "high", header.Get("Priority"))
+	assert.Equal(t, "Bearer secret", header.Get("Authorization"))
+}
+
+// fakeSMTP is a minimal SMTP server accepting a single message
+func fakeSMTP(t *testing.T) (addr string, msg <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ch := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				ch <- data.String()
+				reply("250 ok")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func TestSMTP_Notify(t *testing.T) {
+	addr, msg := fakeSMTP(t)
+	today := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	e := Event{Kind: KindTomorrow, At: today.Add(13 * time.Hour), Prices: pricetest.Prices(today.AddDate(0, 0, 1), time.Hour, 2, 1, 3)}
+	s := SMTP{Addr: addr, From: "power@example.com", To: []string{"me@example.com"}}
+	require.NoError(t, s.Notify(e))
+
+	select {
+	case m := <-msg:
+		assert.Contains(t, m, "Subject: Tomorrow's power prices are ready\r\n")
+		assert.Contains(t, m, "To: me@example.com\r\n")
+		assert.Contains(t, m, "Lowest 1.00 at 01:00, highest 3.00 at 02:00, average 2.00 kr/kWh inc. VAT")
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestSMTP_NotifyTimeout(t *testing.T) {
+	// a server that accepts connections, but never says anything
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s := SMTP{Addr: l.Addr().String(), From: "power@example.com", To: []string{"me@example.com"}, Timeout: 50 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() { done <- s.Notify(Event{Kind: KindTomorrow, At: time.Now()}) }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending to a hung server didn't time out")
+	}
+}
+
+func TestFromConfig_HTTPOptions(t *testing.T) {
+	release := make(chan struct{})
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// the sinks time out like configured, and still don't retry
+	w := FromConfig(config.Notify{Webhook: srv.URL}, transport.Options{Timeout: 50 * time.Millisecond})
+	require.Len(t, w.Sinks, 1)
+	start := time.Now()
+	assert.Error(t, w.Sinks[0].Notify(Event{Kind: KindTomorrow, At: start}))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&posts))
+}
@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/adamhassel/power/transport"
+)
+
+// defaultSMTPTimeout is how long sending an email may take, unless SMTP.Timeout is set
+const defaultSMTPTimeout = 30 * time.Second
+
+// noRetry is the default client of the HTTP sinks. See noRetryClient.
+var noRetry = noRetryClient(transport.Options{})
+
+// noRetryClient returns a client with the timeout and circuit breaker in o,
+// but no retries. A POST that failed may still have been delivered, so
+// retrying it could notify twice.
+func noRetryClient(o transport.Options) *http.Client {
+	o.Retries = -1
+	return transport.New(o)
+}
+
+// Webhook POSTs events as JSON to URL
+type Webhook struct {
+	URL string
+	// Client is the HTTP client used. Default is a client with the default
+	// timeout and circuit breaker of transport.Options, but no retries.
+	Client *http.Client
+}
+
+// Notify implements Sink
+func (w Webhook) Notify(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(w.Client, req)
+}
+
+// Ntfy POSTs events as plain text to an ntfy topic URL, like https://ntfy.sh/mytopic
+type Ntfy struct {
+	URL string
+	// Token is an optional access token
+	Token string
+	// Client is the HTTP client used. Default is a client with the default
+	// timeout and circuit breaker of transport.Options, but no retries.
+	Client *http.Client
+}
+
+// Notify implements Sink
+func (n Ntfy) Notify(e Event) error {
+	req, err := http.NewRequest(http.MethodPost, n.URL, strings.NewReader(e.Message()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", e.Title())
+	req.Header.Set("Tags", "zap,"+string(e.Kind))
+	if e.Kind == KindAbove {
+		req.Header.Set("Priority", "high")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return do(n.Client, req)
+}
+
+// SMTP sends events as emails through the server at Addr (host:port). If
+// Username is set, PLAIN auth is used, which requires TLS unless the server
+// is on localhost.
+type SMTP struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+	// Timeout is how long sending an email may take in total. Default 30s.
+	Timeout time.Duration
+}
+
+// Notify implements Sink
+func (s SMTP) Notify(e Event) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", e.Title())
+	fmt.Fprintf(&msg, "Date: %s\r\n", e.At.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", e.Message())
+	return s.send(host, auth, msg.Bytes())
+}
+
+// send does what smtp.SendMail does, but within the timeout, so a hung server
+// doesn't block the watcher
+func (s SMTP) send(host string, auth smtp.Auth, msg []byte) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+	conn, err := net.DialTimeout("tcp", s.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// do sends req with c, or noRetry if nil, and checks the reply
+func do(c *http.Client, req *http.Request) error {
+	if c == nil {
+		c = noRetry
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned %s, '%s'", req.URL.Host, resp.Status, body)
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package power
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"time"
 
+	"github.com/adamhassel/power/cache"
 	"github.com/adamhassel/power/entities"
 	"github.com/adamhassel/power/interfaces"
 	"github.com/adamhassel/power/repos/eloverblik"
@@ -16,9 +18,24 @@ type FullPrices struct {
 	Contents []entities.FullPrice
 	From     time.Time
 	To       time.Time
+	// Updated is when the prices were fetched
+	Updated time.Time
 }
 
-var FullPricesCached FullPrices
+// FullPricesCached holds the most recently fetched prices for each area
+var FullPricesCached = cache.New[energidataservice.Area, FullPrices]()
+
+// PricesUpdated returns when the cached prices for the area a were fetched, or
+// the zero time if there are none
+func (s *PriceService) PricesUpdated(a energidataservice.Area) time.Time {
+	fp, _ := s.prices.Get(a)
+	return fp.Updated
+}
+
+// PricesUpdated calls PricesUpdated on the default PriceService
+func PricesUpdated(a energidataservice.Area) time.Time {
+	return Default.PricesUpdated(a)
+}
 
 // InRange returns true if fb contains data in the full range from - to
 func (fp FullPrices) InRange(from, to time.Time) bool {
@@ -30,11 +47,8 @@ func (fp FullPrices) InRange(from, to time.Time) bool {
 
 // Price implements the Pricer interface
 func (fp FullPrices) Price(t time.Time) float64 {
-	h := t.Truncate(time.Hour)
-	for _, p := range fp.Contents {
-		if p.ValidFrom.Equal(h) {
-			return p.TotalIncVAT
-		}
+	if p, ok := fp.At(t); ok {
+		return p.TotalIncVAT
 	}
 	return 0.0
 }
@@ -61,35 +75,31 @@ func (fp FullPrices) Range(from, to time.Time) FullPrices {
 	return rv
 }
 
-// Summarize will combine the information in spot and t into a list of FullPrices
+// Summarize will combine the information in spot and t into a list of FullPrices.
+// Prices may be for periods shorter than an hour, in which case the tariffs for
+// the hour they're in apply.
 func Summarize(spot interfaces.SpotPricer, t interfaces.Indexer) FullPrices {
 	var fp = make([]entities.FullPrice, len(spot.SpotPrices()))
 	idx := t.Index()
-	var (
-		to   time.Time
-		from time.Time = time.Now().Add(365 * 24 * time.Hour)
-	)
-	var fromset bool
+	var from, to time.Time
 	for i, p := range spot.SpotPrices() {
-		h := time.Time(p.HourUTC).Local().Hour()
-		taxes := idx.AtPos(h).Taxes()
+		taxes := idx.At(time.Time(p.HourUTC)).Taxes()
 		taxesSubTotal := taxes.Total()
 		// Price data is per MWh, so let's make that per kWh
 		rawPrice := *p.SpotPriceDKK / 1000
 		fp[i] = entities.FullPrice{
 			Taxes:         taxes,
-			ValidFrom:     time.Time(p.HourUTC).Local(),
-			ValidTo:       time.Time(p.HourUTC).Add(time.Hour).Local(),
+			ValidFrom:     time.Time(p.HourUTC).In(entities.Location),
+			ValidTo:       time.Time(p.HourUTC).Add(p.Duration()).In(entities.Location),
 			Estimated:     p.DKKEstimated,
 			EstimatedRate: p.EstimatedRate,
 			RawPrice:      rawPrice,
 			TaxesSubTotal: taxesSubTotal,
 			Total:         taxesSubTotal + rawPrice,
-			TotalIncVAT:   (taxesSubTotal + rawPrice) * 1.25,
+			TotalIncVAT:   (taxesSubTotal + rawPrice) * (1 + vatRate),
 		}
-		if !fromset && fp[i].ValidFrom.Before(from) {
+		if i == 0 || fp[i].ValidFrom.Before(from) {
 			from = fp[i].ValidFrom
-			fromset = true
 		}
 		if fp[i].ValidTo.After(to) {
 			to = fp[i].ValidTo
@@ -103,41 +113,185 @@ func Summarize(spot interfaces.SpotPricer, t interfaces.Indexer) FullPrices {
 	}
 }
 
-var ErrEloverblik = errors.New("error getting data from eloverblik.dk")
+var (
+	ErrEloverblik        = errors.New("error getting data from eloverblik.dk")
+	ErrEnergidataservice = errors.New("error getting data from energidataservice.dk")
+)
 
 // Prices fetches price data from `from` and as far ahead as they're available, for the given `mid` using the
-// `token` for auth. If 'IgnoreMissingTariffs' is true, just return spot prices
-// without tariffs, if they can't be fetched.
-func Prices(from, to time.Time, c interfaces.Configurator, ignoreMissingTariffs bool) ([]entities.FullPrice, error) {
-	// return cached prices if available
-	if FullPricesCached.InRange(from, to) {
-		return FullPricesCached.Range(from, to).Contents, nil
-	}
-	var e energidataservice.EnergiDataService
-	e.Area(energidataservice.AreaDKEast)
-	// always fetch until tomorrow at midnight. If they're not ready yet, the service will return as much as is can.
-	end := time.Now().Truncate(24 * time.Hour).Add(48 * time.Hour)
-	e.Timer(from, end)
-	p, err := e.Query()
+// `token` for auth. The price area is taken from c, or detected from the metering point if not configured.
+// If 'IgnoreMissingTariffs' is true, just return spot prices without tariffs, if they can't be fetched.
+func (s *PriceService) Prices(ctx context.Context, from, to time.Time, c interfaces.Configurator, ignoreMissingTariffs bool) ([]entities.FullPrice, error) {
+	a, err := s.Area(ctx, c)
 	if err != nil {
-		return nil, errors.Wrap(err, ErrEloverblik)
-	}
-
-	// refresh tariffs once a day
-	if time.Now().Sub(eloverblik.FullTariffsCached.UpdatedAt()) > 24*time.Hour {
-		if err := eloverblik.PreloadTariffs(c); err != nil {
-			if ignoreMissingTariffs {
-				log.Printf("encountered error %s, but ignoring", err)
-				err = nil
-			} else {
-				return nil, err
-			}
+		return nil, err
+	}
+	return s.PricesInArea(ctx, from, to, a, c, ignoreMissingTariffs)
+}
+
+// Prices calls Prices on the default PriceService
+func Prices(ctx context.Context, from, to time.Time, c interfaces.Configurator, ignoreMissingTariffs bool) ([]entities.FullPrice, error) {
+	return Default.Prices(ctx, from, to, c, ignoreMissingTariffs)
+}
+
+// detectedAreas caches price areas detected from metering point details, by MID
+var detectedAreas = cache.New[string, energidataservice.Area]()
+
+// ErrNoArea is returned when no price area is configured, and it can't be detected
+var ErrNoArea = errors.New("no price area configured, and detecting it failed")
+
+// Area returns the price area configured in c. If none is configured, it's
+// detected from the details of the metering point. If that fails, the error
+// wraps ErrNoArea.
+func (s *PriceService) Area(ctx context.Context, c interfaces.Configurator) (energidataservice.Area, error) {
+	if c.Area() != "" {
+		return energidataservice.ParseArea(c.Area())
+	}
+	if a, ok := s.areas.Get(c.MID()); ok {
+		return a, nil
+	}
+	a, err := s.areas.Load(ctx, c.MID(), func(ctx context.Context) (energidataservice.Area, error) {
+		mp, err := s.details(ctx, c)
+		if err != nil {
+			return "", err
 		}
+		return mp.PriceArea()
+	})
+	if err != nil {
+		return "", errors.Wrap(err, ErrNoArea)
+	}
+	return a, nil
+}
+
+// MeteringPoint returns the details of the metering point configured in c
+func (s *PriceService) MeteringPoint(ctx context.Context, c interfaces.Configurator) (eloverblik.MeteringPoint, error) {
+	return s.details(ctx, c)
+}
+
+// Area calls Area on the default PriceService
+func Area(ctx context.Context, c interfaces.Configurator) (energidataservice.Area, error) {
+	return Default.Area(ctx, c)
+}
+
+// PricesInArea works like Prices, but for the price area a, regardless of what's configured in c.
+func (s *PriceService) PricesInArea(ctx context.Context, from, to time.Time, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) ([]entities.FullPrice, error) {
+	// return cached prices if available
+	if cached, _ := s.prices.Get(a); cached.InRange(from, to) {
+		return cached.Range(from, to).Contents, nil
+	}
+	if stored, ok := s.storedPrices(a, from, to); ok {
+		return stored.Range(from, to).Contents, nil
+	}
+	if to.Before(s.clock.Now().Truncate(time.Hour)) {
+		ps, _, err := s.historicalPrices(ctx, from, to, a, c, ignoreMissingTariffs)
+		return ps, err
+	}
+	load := func(ctx context.Context) (FullPrices, error) {
+		return s.currentPrices(ctx, from, a, c)
+	}
+	fp, err := s.prices.Load(ctx, a, load)
+	if err == nil && fp.From.After(from) {
+		// we got the prices from a fetch already in progress, which started later than we need
+		fp, err = s.prices.Load(ctx, a, load)
+	}
+	var missing *missingTariffsError
+	if errors.As(err, &missing) && ignoreMissingTariffs {
+		slog.WarnContext(ctx, "error refreshing tariffs, using prices without them", "err", missing.err)
+		fp, err = missing.prices, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	return fp.Range(from, to).Contents, nil
+}
+
+// PricesInArea calls PricesInArea on the default PriceService
+func PricesInArea(ctx context.Context, from, to time.Time, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) ([]entities.FullPrice, error) {
+	return Default.PricesInArea(ctx, from, to, a, c, ignoreMissingTariffs)
+}
+
+// missingTariffsError is returned by currentPrices when the tariffs can't be
+// refreshed. It has the prices without them, for those who'll do without, but
+// being an error, they aren't cached.
+type missingTariffsError struct {
+	prices FullPrices
+	err    error
+}
+
+func (e *missingTariffsError) Error() string {
+	return e.err.Error()
+}
 
-	FullPricesCached = Summarize(p.(energidataservice.Prices), eloverblik.FullTariffsCached)
-	return FullPricesCached.Range(from, to).Contents, nil
+func (e *missingTariffsError) Unwrap() error {
+	return e.err
+}
+
+// currentPrices fetches prices in the area a from `from` until tomorrow at midnight. If they're not ready yet, the
+// service will return as much as is can. If the tariffs can't be refreshed, the error is a *missingTariffsError.
+func (s *PriceService) currentPrices(ctx context.Context, from time.Time, a energidataservice.Area, c interfaces.Configurator) (FullPrices, error) {
+	p, err := s.spotPrices(ctx, from, s.clock.Now().Truncate(24*time.Hour).Add(48*time.Hour), a)
+	if err != nil {
+		return FullPrices{}, err
+	}
+
+	tariffsErr := s.RefreshTariffs(ctx, c)
+	fp := Summarize(p, s.CachedTariffs(c.MID()))
+	fp.Updated = s.clock.Now()
+	s.save(a, p, fp)
+	if tariffsErr != nil {
+		return FullPrices{}, &missingTariffsError{prices: fp, err: tariffsErr}
+	}
+	return fp, nil
+}
+
+// historicalPrices fetches prices in the area a from `from` to `to`, with the tariffs known at the time of each day.
+// History isn't cached in memory, to keep the current prices there. Full prices are only saved in the store for days
+// with a snapshot of the tariffs from the time, and unsaved is the number of prices that weren't.
+func (s *PriceService) historicalPrices(ctx context.Context, from, to time.Time, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) (ps []entities.FullPrice, unsaved int, err error) {
+	p, err := s.spotPrices(ctx, from, to, a)
+	if err != nil {
+		return nil, 0, err
+	}
+	var all, known FullPrices
+	for _, day := range byDay(p.Elspotprices) {
+		start := time.Time(day[0].HourUTC).In(entities.Location)
+		end := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, entities.Location)
+		ft, stored, err := s.tariffsAt(ctx, c, end)
+		if err != nil && !ignoreMissingTariffs {
+			return nil, 0, err
+		}
+		fp := Summarize(energidataservice.Prices{Elspotprices: day}, ft)
+		all.Contents = append(all.Contents, fp.Contents...)
+		if stored {
+			known.Contents = append(known.Contents, fp.Contents...)
+		} else {
+			unsaved += len(fp.Contents)
+		}
+	}
+	s.save(a, p, known)
+	return all.Range(from, to).Contents, unsaved, nil
+}
+
+// byDay splits ps into the prices of each day in Danish time
+func byDay(ps []entities.Elspotprice) [][]entities.Elspotprice {
+	var days [][]entities.Elspotprice
+	var last string
+	for i, p := range ps {
+		if d := time.Time(p.HourUTC).In(entities.Location).Format("2006-01-02"); i == 0 || d != last {
+			days = append(days, nil)
+			last = d
+		}
+		days[len(days)-1] = append(days[len(days)-1], p)
+	}
+	return days
+}
+
+// spotPrices fetches spot prices in the area a from `from` to `to`. The
+// provider has already estimated any missing DKK prices.
+func (s *PriceService) spotPrices(ctx context.Context, from, to time.Time, a energidataservice.Area) (energidataservice.Prices, error) {
+	ps, err := s.spot.SpotPrices(ctx, from, to, string(a))
+	if err != nil {
+		return energidataservice.Prices{}, errors.Wrap(err, ErrEnergidataservice)
+	}
+	return energidataservice.Prices{Total: len(ps), Elspotprices: ps}, nil
 }
@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/repos/energidataservice"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFullPrices_InRange(t *testing.T) {
@@ -148,7 +150,7 @@ func TestFullPrices_Range(t *testing.T) {
 				from: now.Add(time.Hour),
 				to:   now.Add(3 * time.Hour),
 			},
-			want: FullPrices{testdata.Contents[1:3], now.Add(time.Hour), now.Add(3 * time.Hour)},
+			want: FullPrices{Contents: testdata.Contents[1:3], From: now.Add(time.Hour), To: now.Add(3 * time.Hour)},
 		},
 	}
 	for _, tt := range tests {
@@ -162,3 +164,99 @@ func TestFullPrices_Range(t *testing.T) {
 		})
 	}
 }
+
+type testIndex entities.TariffIndex
+
+func (t testIndex) Index() entities.TariffIndex {
+	return entities.TariffIndex(t)
+}
+
+func TestSummarize_QuarterHours(t *testing.T) {
+	start := time.Date(2025, 10, 2, 10, 0, 0, 0, entities.Location)
+	var spot energidataservice.Prices
+	for i := 0; i < 8; i++ {
+		price := float64(i * 100)
+		spot.Elspotprices = append(spot.Elspotprices, entities.Elspotprice{
+			HourUTC:      entities.PTime(start.Add(time.Duration(i) * 15 * time.Minute).UTC()),
+			SpotPriceDKK: &price,
+			Resolution:   15 * time.Minute,
+		})
+	}
+	idx := testIndex{{Positions: map[int][]entities.Tariff{
+		0:  {{Name: "nettarif", Price: 0.1}},
+		10: {{Name: "nettarif", Price: 0.5}},
+		11: {{Name: "nettarif", Price: 1}},
+	}}}
+
+	fp := Summarize(spot, idx)
+	require.Len(t, fp.Contents, 8)
+	assert.True(t, fp.From.Equal(start))
+	assert.True(t, fp.To.Equal(start.Add(2*time.Hour)))
+	for i, p := range fp.Contents {
+		assert.Equal(t, 15*time.Minute, p.ValidTo.Sub(p.ValidFrom))
+		want := 0.5
+		if i >= 4 {
+			want = 1
+		}
+		assert.Equal(t, want, p.TaxesSubTotal, "quarter %d", i)
+	}
+	assert.Equal(t, fp.Contents[5].TotalIncVAT, fp.Price(start.Add(80*time.Minute)))
+}
+
+func TestSummarize_DaylightSaving(t *testing.T) {
+	// make sure the host's time zone doesn't matter
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	// each position has its own price, equal to the position
+	positions := make(map[int][]entities.Tariff)
+	for i := 0; i < 24; i++ {
+		positions[i] = []entities.Tariff{{Name: "nettarif", Price: float64(i)}}
+	}
+	idx := testIndex{{Positions: positions}}
+
+	tests := []struct {
+		name  string
+		day   time.Time
+		hours int
+		want  []int
+	}{
+		{
+			name:  "daylight saving time starts",
+			day:   time.Date(2022, 3, 27, 0, 0, 0, 0, entities.Location),
+			hours: 23,
+			want:  []int{0, 1, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23},
+		},
+		{
+			name:  "daylight saving time ends",
+			day:   time.Date(2022, 10, 30, 0, 0, 0, 0, entities.Location),
+			hours: 25,
+			want:  []int{0, 1, 2, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := tt.day.AddDate(0, 0, 1)
+			require.Equal(t, time.Duration(tt.hours)*time.Hour, next.Sub(tt.day))
+			var spot energidataservice.Prices
+			for h := tt.day; h.Before(next); h = h.Add(time.Hour) {
+				price := 0.0
+				spot.Elspotprices = append(spot.Elspotprices, entities.Elspotprice{
+					HourUTC:      entities.PTime(h.UTC()),
+					SpotPriceDKK: &price,
+				})
+			}
+			fp := Summarize(spot, idx)
+			require.Len(t, fp.Contents, tt.hours)
+			got := make([]int, len(fp.Contents))
+			for i, p := range fp.Contents {
+				got[i] = int(p.TaxesSubTotal)
+				assert.Equal(t, entities.Location, p.ValidFrom.Location())
+			}
+			assert.Equal(t, tt.want, got)
+			assert.True(t, fp.From.Equal(tt.day))
+			assert.True(t, fp.To.Equal(next))
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package eloverblik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/transport"
+)
+
+// Resolution is the aggregation level of consumption data returned from eloverblik
+type Resolution string
+
+const (
+	// ResolutionActual returns data in the resolution the meter reports it, usually hourly or quarterly
+	ResolutionActual Resolution = "Actual"
+	// ResolutionQuarter returns data per 15 minutes
+	ResolutionQuarter Resolution = "Quarter"
+	// ResolutionHour returns data per hour
+	ResolutionHour Resolution = "Hour"
+)
+
+var ErrNoTimeSeries = errors.New("no time series in response from eloverblik")
+
+// TimeSeries is the data format returned from eloverblik, containing metered consumption.
+type TimeSeries struct {
+	Result []struct {
+		MyEnergyDataMarketDocument struct {
+			MRID            string `json:"mRID"`
+			CreatedDateTime string `json:"createdDateTime"`
+			TimeSeries      []struct {
+				MRID            string `json:"mRID"`
+				BusinessType    string `json:"businessType"`
+				CurveType       string `json:"curveType"`
+				MeasurementUnit string `json:"measurement_Unit.name"`
+				Period          []struct {
+					Resolution   string `json:"resolution"`
+					TimeInterval struct {
+						Start string `json:"start"`
+						End   string `json:"end"`
+					} `json:"timeInterval"`
+					Point []struct {
+						Position string `json:"position"`
+						Quantity string `json:"out_Quantity.quantity"`
+						Quality  string `json:"out_Quantity.quality"`
+					} `json:"Point"`
+				} `json:"Period"`
+			} `json:"TimeSeries"`
+		} `json:"MyEnergyData_MarketDocument"`
+		Success       bool        `json:"success"`
+		ErrorCode     int         `json:"errorCode"`
+		ErrorCodeEnum string      `json:"errorCodeEnum"`
+		ErrorText     string      `json:"errorText"`
+		Id            string      `json:"id"`
+		StackTrace    interface{} `json:"stackTrace"`
+	} `json:"result"`
+}
+
+// Resolution sets the resolution of the consumption fetched. Default is ResolutionHour.
+func (e *Eloverblik) Resolution(r Resolution) {
+	e.resolution = r
+}
+
+// Consumption fetches metered consumption for the configured metering point
+// from `from` to `to`. Eloverblik only accepts whole days, so the range is
+// expanded to cover full days.
+func (e *Eloverblik) Consumption(ctx context.Context, from, to time.Time) (entities.Consumptions, error) {
+	var ts TimeSeries
+	if err := e.withAuth(ctx, func(token []byte) error {
+		ts = TimeSeries{}
+		return ts.query(ctx, transport.Client(e.client), e.baseURL(), token, e.mid, from, to, e.resolution)
+	}); err != nil {
+		return nil, err
+	}
+	return ts.Consumptions()
+}
+
+func (ts *TimeSeries) query(ctx context.Context, client *http.Client, base string, token []byte, mid string, from, to time.Time, r Resolution) error {
+	if r == "" {
+		r = ResolutionHour
+	}
+	from, to = from.In(entities.Location), to.In(entities.Location)
+	path := fmt.Sprintf("/meterdata/gettimeseries/%s/%s/%s", from.Format("2006-01-02"), to.AddDate(0, 0, 1).Format("2006-01-02"), r)
+	response, err := postMeteringPoint(ctx, client, base, path, token, mid)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(response, &ts)
+}
+
+// Consumptions flattens ts into a list of readings, sorted by time
+func (ts TimeSeries) Consumptions() (entities.Consumptions, error) {
+	var rv entities.Consumptions
+	for _, res := range ts.Result {
+		if !res.Success {
+			return nil, fmt.Errorf("eloverblik error %d: %s", res.ErrorCode, res.ErrorText)
+		}
+		for _, series := range res.MyEnergyDataMarketDocument.TimeSeries {
+			for _, period := range series.Period {
+				start, err := time.Parse(time.RFC3339, period.TimeInterval.Start)
+				if err != nil {
+					return nil, err
+				}
+				step, err := parseResolution(period.Resolution)
+				if err != nil {
+					return nil, err
+				}
+				for _, p := range period.Point {
+					pos, err := strconv.Atoi(p.Position)
+					if err != nil {
+						return nil, err
+					}
+					q, err := strconv.ParseFloat(p.Quantity, 64)
+					if err != nil {
+						return nil, err
+					}
+					from := start.Add(time.Duration(pos-1) * step)
+					rv = append(rv, entities.Consumption{
+						ValidFrom: from.In(entities.Location),
+						ValidTo:   from.Add(step).In(entities.Location),
+						KWh:       q,
+						Quality:   p.Quality,
+					})
+				}
+			}
+		}
+	}
+	if rv == nil {
+		return nil, ErrNoTimeSeries
+	}
+	return rv, nil
+}
+
+var resolutionRe = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?$`)
+
+// parseResolution parses the ISO 8601 durations used by eloverblik, like PT1H and PT15M
+func parseResolution(s string) (time.Duration, error) {
+	m := resolutionRe.FindStringSubmatch(s)
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return 0, fmt.Errorf("unsupported resolution '%s'", s)
+	}
+	h, _ := strconv.Atoi(m[1])
+	mins, _ := strconv.Atoi(m[2])
+	return time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute, nil
+}
+
+// FetchConsumption gets metered consumption from `from` to `to` for the metering point in c.
+func FetchConsumption(ctx context.Context, c interfaces.Configurator, from, to time.Time, r Resolution) (entities.Consumptions, error) {
+	e := FromConfig(c)
+	e.Resolution(r)
+	cs, err := e.Consumption(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return cs.Range(from, to), nil
+}
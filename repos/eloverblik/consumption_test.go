@@ -0,0 +1,53 @@
+package eloverblik
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const timeSeriesResponse = `{"result":[{"MyEnergyData_MarketDocument":{"mRID":"doc","createdDateTime":"2022-02-08T10:00:00Z","TimeSeries":[{"mRID":"571313100000000000","businessType":"A04","curveType":"A01","measurement_Unit.name":"KWH","Period":[{"resolution":"PT1H","timeInterval":{"start":"2022-02-06T23:00:00Z","end":"2022-02-07T23:00:00Z"},"Point":[{"position":"1","out_Quantity.quantity":"0.25","out_Quantity.quality":"A04"},{"position":"2","out_Quantity.quantity":"1.5","out_Quantity.quality":"A04"}]},{"resolution":"PT15M","timeInterval":{"start":"2022-02-07T23:00:00Z","end":"2022-02-08T23:00:00Z"},"Point":[{"position":"1","out_Quantity.quantity":"0.1","out_Quantity.quality":"A04"},{"position":"4","out_Quantity.quantity":"0.2","out_Quantity.quality":"A03"}]}]}]},"success":true,"errorCode":10000,"errorCodeEnum":"NoError","errorText":"NoError","id":"571313100000000000","stackTrace":null}]}`
+
+func TestTimeSeries_Consumptions(t *testing.T) {
+	var ts TimeSeries
+	require.NoError(t, json.Unmarshal([]byte(timeSeriesResponse), &ts))
+	cs, err := ts.Consumptions()
+	require.NoError(t, err)
+	require.Len(t, cs, 4)
+
+	start := time.Date(2022, 2, 6, 23, 0, 0, 0, time.UTC)
+	assert.True(t, cs[0].ValidFrom.Equal(start))
+	assert.True(t, cs[0].ValidTo.Equal(start.Add(time.Hour)))
+	assert.True(t, cs[1].ValidFrom.Equal(start.Add(time.Hour)))
+	assert.Equal(t, 1.5, cs[1].KWh)
+
+	quarter := start.Add(24 * time.Hour)
+	assert.True(t, cs[3].ValidFrom.Equal(quarter.Add(45*time.Minute)))
+	assert.True(t, cs[3].ValidTo.Equal(quarter.Add(time.Hour)))
+	assert.Equal(t, "A03", cs[3].Quality)
+	assert.InDelta(t, 2.05, cs.Total(), 1e-9)
+}
+
+func TestTimeSeries_ConsumptionsError(t *testing.T) {
+	var ts TimeSeries
+	require.NoError(t, json.Unmarshal([]byte(`{"result":[{"success":false,"errorCode":20000,"errorText":"WrongNumberOfArguments"}]}`), &ts))
+	_, err := ts.Consumptions()
+	assert.Error(t, err)
+}
+
+func Test_parseResolution(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"PT1H":    time.Hour,
+		"PT15M":   15 * time.Minute,
+		"PT1H30M": 90 * time.Minute,
+	} {
+		got, err := parseResolution(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := parseResolution("P1D")
+	assert.Error(t, err)
+}
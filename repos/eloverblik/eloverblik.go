@@ -1,35 +1,44 @@
 package eloverblik
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/adamhassel/power/cache"
 	"github.com/adamhassel/power/entities"
 	"github.com/adamhassel/power/entities/config"
 	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/logging"
+	"github.com/adamhassel/power/metrics"
+	"github.com/adamhassel/power/transport"
 	"github.com/tidwall/gjson"
 )
 
-const elOverblikUrl = "https://api.eloverblik.dk/CustomerApi/api"
+// DefaultURL is the base URL of the eloverblik customer API, unless one is set
+// with URL
+var DefaultURL = "https://api.eloverblik.dk/CustomerApi/api"
 
 var ErrAuth = errors.New("authorization error")
 
-var FullTariffsCached FullTariffs
+// FullTariffsCached holds the most recently fetched tariffs for each metering point
+var FullTariffsCached = cache.New[string, FullTariffs]()
 
-// Eloverblik implements the interfaces for getting tariffs
+// Eloverblik gets tariffs, consumption and details for a metering point. It
+// implements interfaces.TariffProvider and interfaces.ConsumptionProvider.
 type Eloverblik struct {
 	authToken    []byte
 	refreshToken []byte
 	mid          string
-	ft           FullTariffs
+	resolution   Resolution
+	client       *http.Client
+	url          string
 	rg           bool
 }
 
@@ -41,47 +50,35 @@ type RequestData struct {
 }
 
 // FullTariffs is the data format returned from eloverblik, containg tariff information.
-type FullTariffs struct {
-	Result []struct {
-		Result struct {
-			MeteringPointId string `json:"meteringPointId"`
-			Subscriptions   []struct {
-				SubscriptionId interface{} `json:"subscriptionId"`
-				Name           string      `json:"name"`
-				Description    string      `json:"description"`
-				Owner          string      `json:"owner"`
-				ValidFromDate  string      `json:"validFromDate"`
-				ValidToDate    interface{} `json:"validToDate"`
-				Price          float64     `json:"price"`
-				Quantity       int         `json:"quantity"`
-			} `json:"subscriptions"`
-			Fees    []interface{} `json:"fees"`
-			Tariffs []struct {
-				TariffId      interface{} `json:"tariffId"`
-				Name          string      `json:"name"`
-				Description   string      `json:"description"`
-				Owner         string      `json:"owner"`
-				PeriodType    string      `json:"periodType"`
-				ValidFromDate string      `json:"validFromDate"`
-				ValidToDate   *string     `json:"validToDate"`
-				Prices        []struct {
-					Position string  `json:"position"`
-					Price    float64 `json:"price"`
-				} `json:"prices"`
-			} `json:"tariffs"`
-		} `json:"result"`
-		Success       bool        `json:"success"`
-		ErrorCode     int         `json:"errorCode"`
-		ErrorCodeEnum string      `json:"errorCodeEnum"`
-		ErrorText     string      `json:"errorText"`
-		Id            string      `json:"id"`
-		StackTrace    interface{} `json:"stackTrace"`
-	} `json:"result"`
-	ts time.Time `json:"-"`
+type FullTariffs = entities.FullTariffs
+
+// FromConfig returns an Eloverblik for the metering point in c, using the token in c for auth
+func FromConfig(c interfaces.Configurator) *Eloverblik {
+	if c == nil {
+		c = config.GetConf()
+	}
+	var e Eloverblik
+	e.Authenticate([]byte(c.Token()))
+	e.Identify([]byte(c.MID()))
+	return &e
 }
 
-func (ft FullTariffs) UpdatedAt() time.Time {
-	return ft.ts
+// Client sets the HTTP client used for requests. Default is transport.Default.
+func (e *Eloverblik) Client(c *http.Client) {
+	e.client = c
+}
+
+// URL sets the base URL of the API. Default is DefaultURL.
+func (e *Eloverblik) URL(u string) {
+	e.url = u
+}
+
+// baseURL returns the base URL of the API
+func (e *Eloverblik) baseURL() string {
+	if e.url != "" {
+		return strings.TrimSuffix(e.url, "/")
+	}
+	return strings.TrimSuffix(DefaultURL, "/")
 }
 
 func (e *Eloverblik) Authenticate(token []byte) error {
@@ -90,8 +87,8 @@ func (e *Eloverblik) Authenticate(token []byte) error {
 }
 
 // ExecAuth performs the actual authentication step and stores/refreshes the refresh token
-func (e *Eloverblik) ExecAuth() error {
-	t, err := getRefreshToken(e.authToken)
+func (e *Eloverblik) ExecAuth(ctx context.Context) error {
+	t, err := getRefreshToken(ctx, transport.Client(e.client), e.baseURL(), e.authToken)
 	if err != nil {
 		return err
 	}
@@ -104,131 +101,93 @@ func (e *Eloverblik) Identify(mid []byte) error {
 	return nil
 }
 
-func (e *Eloverblik) Query() (interface{}, error) {
-	if e.refreshToken == nil {
-		if err := e.ExecAuth(); err != nil {
-			return nil, err
-		}
-	}
-	e.ft = FullTariffs{}
-	if err := e.ft.query(e.refreshToken, e.mid); err != nil {
-		if errors.Is(err, ErrAuth) && !e.rg {
-			e.refreshToken = nil
-			e.rg = true
-			return e.Query()
-		}
-		return nil, err
+// Tariffs fetches the tariffs for the metering point. eloverblik only returns
+// the charges currently attached to it, which include any that start in the
+// future, so the range is only a hint. The tariffs aren't stamped with when
+// they were fetched; that's up to the caller.
+func (e *Eloverblik) Tariffs(ctx context.Context, from, to time.Time) (FullTariffs, error) {
+	var ft FullTariffs
+	if err := e.withAuth(ctx, func(token []byte) error {
+		var err error
+		ft, err = queryTariffs(ctx, transport.Client(e.client), e.baseURL(), token, e.mid)
+		return err
+	}); err != nil {
+		return FullTariffs{}, err
 	}
-	e.ft.ts = time.Now()
-	return e.ft, nil
-}
-
-func (e Eloverblik) FullTariffs() FullTariffs {
-	return e.ft
+	return ft, nil
 }
 
-// Index tariffs by position (hour)
-func (ft FullTariffs) Index() entities.TariffIndex {
-	rv := make(entities.TariffIndex)
-	var m entities.TariffMetadata
-	m.Positions = entities.NewIntSet()
-	for _, res := range ft.Result {
-		for _, tar := range res.Result.Tariffs {
-			m.PositionCount = max(m.PositionCount, len(tar.Prices))
-			for _, p := range tar.Prices {
-				pos, _ := strconv.Atoi(p.Position)
-				m.Positions.Add(pos)
-			}
+// withAuth calls f with a refresh token, authenticating first if needed. If f
+// fails with ErrAuth, the refresh token is renewed and f is retried once.
+func (e *Eloverblik) withAuth(ctx context.Context, f func(token []byte) error) error {
+	if e.refreshToken == nil {
+		if err := e.ExecAuth(ctx); err != nil {
+			return err
 		}
 	}
-	for _, res := range ft.Result {
-		for _, tar := range res.Result.Tariffs {
-			tariff := entities.Tariff{
-				TariffId:      tar.TariffId,
-				Name:          tar.Name,
-				Description:   tar.Description,
-				Owner:         tar.Owner,
-				PeriodType:    tar.PeriodType,
-				ValidFromDate: tar.ValidFromDate,
-				ValidToDate:   tar.ValidToDate,
-			}
-
-			for i := 0; i < m.PositionCount; i++ {
-				pos := 0
-				if len(tar.Prices) > i {
-					pos, _ = strconv.Atoi(tar.Prices[i].Position)
-					pos--
-				}
-				ptariff := tariff
-				ptariff.Price = tar.Prices[pos].Price
-				rv[i] = append(rv[i], ptariff)
-
-			}
+	err := f(e.refreshToken)
+	if errors.Is(err, ErrAuth) && !e.rg {
+		e.refreshToken = nil
+		e.rg = true
+		if err := e.ExecAuth(ctx); err != nil {
+			return err
 		}
+		err = f(e.refreshToken)
 	}
-	return rv
+	return err
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+func queryTariffs(ctx context.Context, client *http.Client, base string, token []byte, mid string) (FullTariffs, error) {
+	var ft FullTariffs
+	response, err := postMeteringPoint(ctx, client, base, "/meteringpoints/meteringpoint/getcharges", token, mid)
+	if err != nil {
+		return ft, err
 	}
-	return b
+	err = json.Unmarshal(response, &ft)
+	return ft, err
 }
 
-func (ft *FullTariffs) query(token []byte, mid string) error {
-	u, _ := url.Parse(elOverblikUrl + "/meteringpoints/meteringpoint/getcharges")
-	var h = make(http.Header)
-	h.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	h.Add("Content-Type", "application/json")
-	r := &http.Request{
-		Method: "POST",
-		URL:    u,
-		Header: h,
-		Body:   ioutil.NopCloser(strings.NewReader(makeMeteringPointBody(mid))),
+// postMeteringPoint POSTs a request for data on the metering point mid to the
+// eloverblik endpoint at path below base, and returns the raw response
+func postMeteringPoint(ctx context.Context, client *http.Client, base, path string, token []byte, mid string) (_ []byte, err error) {
+	defer metrics.ObserveUpstream(metrics.Eloverblik, time.Now(), &err)
+	body := makeMeteringPointBody(mid)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, base+path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
-	c := http.Client{}
-	out, _ := httputil.DumpRequest(r, true)
-	fmt.Println(string(out))
-	resp, err := c.Do(r)
+	r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	r.Header.Add("Content-Type", "application/json")
+	slog.DebugContext(ctx, "eloverblik request", "path", path, "body", body)
+	resp, err := client.Do(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	slog.DebugContext(ctx, "eloverblik response", "path", path, "status", resp.StatusCode, "body", logging.Body(response))
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println(resp.Status)
-		fmt.Println(string(response))
-	}
 	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
-		return ErrAuth
+		return nil, ErrAuth
 	}
-
-	if err := json.Unmarshal(response, &ft); err != nil {
-		return err
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("eloverblik returned %s, '%s'", resp.Status, response)
 	}
-	return nil
+	return response, nil
 }
 
-func getRefreshToken(token []byte) (string, error) {
-	u, _ := url.Parse(elOverblikUrl + "/token")
-	var h = make(http.Header)
-	h.Add("Authorization", fmt.Sprintf("Bearer %s", string(token)))
-	r := &http.Request{
-		Method: "GET",
-		URL:    u,
-		Header: h,
+func getRefreshToken(ctx context.Context, client *http.Client, base string, token []byte) (_ string, err error) {
+	defer metrics.ObserveUpstream(metrics.Eloverblik, time.Now(), &err)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/token", nil)
+	if err != nil {
+		return "", err
 	}
-
-	c := http.Client{}
-	out, _ := httputil.DumpRequest(r, true)
-	fmt.Println(string(out))
-	resp, err := c.Do(r)
+	r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", string(token)))
+	resp, err := client.Do(r)
 	if err != nil {
 		return "", err
 	}
@@ -238,6 +197,8 @@ func getRefreshToken(token []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// the body is the data access token, so it's never logged
+	slog.DebugContext(ctx, "eloverblik response", "path", "/token", "status", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		return "", errors.New(resp.Status)
@@ -257,25 +218,32 @@ func makeMeteringPointBody(mid string) string {
 	return string(rv)
 }
 
-func PreloadTariffs(c interfaces.Configurator) error {
-	var t Eloverblik
-	if c == nil {
-		c = config.GetConf()
-	}
-	if err := t.Authenticate([]byte(c.Token())); err != nil {
-		return err
-	}
-	if err := t.Identify([]byte(c.MID())); err != nil {
-		return err
-	}
-	ft, err := t.Query()
+// FetchTariffs fetches the tariffs for the metering point configured in c,
+// stamped with the current time
+func FetchTariffs(ctx context.Context, c interfaces.Configurator) (FullTariffs, error) {
+	now := time.Now()
+	ft, err := FromConfig(c).Tariffs(ctx, now, now)
 	if err != nil {
-		return err
+		return ft, err
 	}
-	var ok bool
-	FullTariffsCached, ok = ft.(FullTariffs)
-	if !ok {
-		return errors.New("return from eloverblik were not in expected format. This is weird")
+	ft.SetUpdatedAt(now)
+	return ft, nil
+}
+
+// PreloadTariffs fetches the tariffs for the metering point configured in c into FullTariffsCached
+func PreloadTariffs(ctx context.Context, c interfaces.Configurator) error {
+	if c == nil {
+		c = config.GetConf()
 	}
-	return nil
+	_, err := FullTariffsCached.Load(ctx, c.MID(), func(ctx context.Context) (FullTariffs, error) {
+		return FetchTariffs(ctx, c)
+	})
+	return err
+}
+
+// CachedTariffs returns the tariffs in FullTariffsCached for the metering point
+// mid, which are empty if none have been fetched
+func CachedTariffs(mid string) FullTariffs {
+	ft, _ := FullTariffsCached.Get(mid)
+	return ft
 }
@@ -0,0 +1,91 @@
+package eloverblik
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// two grid tariffs, the second replacing the first on April 1st, and a tax without a validity date
+const chargesResponse = `{"result":[{"result":{"meteringPointId":"571313100000000000",
+"subscriptions":[{"subscriptionId":"1","name":"Netabonnement","owner":"Radius","validFromDate":"2021-12-31T23:00:00.000Z","validToDate":null,"price":21.0,"quantity":1}],
+"fees":[],
+"tariffs":[
+{"tariffId":"1","name":"Nettarif","owner":"Radius","periodType":"P1H","validFromDate":"2021-12-31T23:00:00.000Z","validToDate":"2022-03-31T22:00:00.000Z","prices":[{"position":"1","price":0.1},{"position":"2","price":0.2},{"position":"3","price":0.3}]},
+{"tariffId":"2","name":"Nettarif","owner":"Radius","periodType":"P1H","validFromDate":"2022-03-31T22:00:00.000Z","validToDate":null,"prices":[{"position":"1","price":1.1},{"position":"2","price":1.2},{"position":"3","price":1.3}]},
+{"tariffId":"3","name":"Elafgift","owner":"Energinet","periodType":"P1D","validFromDate":"","validToDate":null,"prices":[{"position":"1","price":0.9}]}
+]},"success":true,"errorCode":10000,"errorText":"NoError","id":"571313100000000000"}]}`
+
+func TestFullTariffs_Index(t *testing.T) {
+	var ft FullTariffs
+	require.NoError(t, json.Unmarshal([]byte(chargesResponse), &ft))
+	idx := ft.Index()
+	require.Len(t, idx, 3)
+
+	boundary := time.Date(2022, 3, 31, 22, 0, 0, 0, time.UTC)
+	assert.True(t, idx[0].ValidFrom.IsZero())
+	assert.True(t, idx[1].ValidTo.Equal(boundary))
+	assert.True(t, idx[2].ValidFrom.Equal(boundary))
+	assert.True(t, idx[2].ValidTo.IsZero())
+
+	// before any grid tariff, only the tax applies
+	before := idx.At(time.Date(2021, 6, 1, 1, 0, 0, 0, entities.Location))
+	require.Len(t, before, 1)
+	assert.Equal(t, 0.9, before.Taxes().Total())
+
+	// position 2 (01:00-02:00) before and after the change
+	old := idx.At(time.Date(2022, 3, 1, 1, 0, 0, 0, entities.Location))
+	assert.InDelta(t, 1.1, old.Taxes().Total(), 1e-9)
+	current := idx.At(time.Date(2022, 4, 2, 1, 0, 0, 0, entities.Location))
+	assert.InDelta(t, 2.1, current.Taxes().Total(), 1e-9)
+
+	// positions beyond those given fall back to the first
+	assert.InDelta(t, 2.0, idx.At(time.Date(2022, 4, 2, 12, 0, 0, 0, entities.Location)).Taxes().Total(), 1e-9)
+}
+
+func TestFullTariffs_Charges(t *testing.T) {
+	var ft FullTariffs
+	require.NoError(t, json.Unmarshal([]byte(chargesResponse), &ft))
+	ch := ft.Charges()
+	require.Len(t, ch, 1)
+	assert.Equal(t, "Netabonnement", ch[0].Name)
+	assert.False(t, ch[0].Fee)
+	assert.True(t, ch[0].ValidAt(time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, ch[0].ValidAt(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestMeteringPoint_PriceArea(t *testing.T) {
+	tests := []struct {
+		name string
+		mp   MeteringPoint
+		want energidataservice.Area
+	}{
+		{name: "Copenhagen", mp: MeteringPoint{Postcode: "2100", MeteringGridAreaIdentification: "791"}, want: energidataservice.AreaDKEast},
+		{name: "Bornholm", mp: MeteringPoint{Postcode: "3700"}, want: energidataservice.AreaDKEast},
+		{name: "Odense", mp: MeteringPoint{Postcode: "5000"}, want: energidataservice.AreaDKWest},
+		{name: "Aarhus", mp: MeteringPoint{Postcode: "8000", MeteringGridAreaIdentification: "151"}, want: energidataservice.AreaDKWest},
+		{name: "grid area only, east", mp: MeteringPoint{MeteringGridAreaIdentification: "740"}, want: energidataservice.AreaDKEast},
+		{name: "grid area only, west", mp: MeteringPoint{MeteringGridAreaIdentification: "031"}, want: energidataservice.AreaDKWest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mp.PriceArea()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	_, err := MeteringPoint{}.PriceArea()
+	assert.Error(t, err)
+}
+
+func TestMeteringPoint_Address(t *testing.T) {
+	mp := MeteringPoint{StreetName: "Vestergade", BuildingNumber: "12", FloorId: "3", RoomId: "tv", Postcode: "8000", CityName: "Aarhus C"}
+	assert.Equal(t, "Vestergade 12, 3 tv, 8000 Aarhus C", mp.Address())
+	mp.FloorId, mp.RoomId = "", ""
+	assert.Equal(t, "Vestergade 12, 8000 Aarhus C", mp.Address())
+}
@@ -0,0 +1,164 @@
+package eloverblik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/transport"
+)
+
+var ErrNoDetails = errors.New("no metering point details in response from eloverblik")
+
+// MeteringPoint is the details eloverblik has about a metering point
+type MeteringPoint struct {
+	MeteringPointId                string `json:"meteringPointId"`
+	TypeOfMP                       string `json:"typeOfMP"`
+	SubTypeOfMP                    string `json:"subTypeOfMP"`
+	SettlementMethod               string `json:"settlementMethod"`
+	MeterReadingOccurrence         string `json:"meterReadingOccurrence"`
+	MeterNumber                    string `json:"meterNumber"`
+	PhysicalStatusOfMP             string `json:"physicalStatusOfMP"`
+	MeteringGridAreaIdentification string `json:"meteringGridAreaIdentification"`
+	NetSettlementGroup             string `json:"netSettlementGroup"`
+	GridOperatorName               string `json:"gridOperatorName"`
+	BalanceSupplierName            string `json:"balanceSupplierName"`
+	BalanceSupplierStartDate       string `json:"balanceSupplierStartDate"`
+	ConsumerStartDate              string `json:"consumerStartDate"`
+	EstimatedAnnualVolume          string `json:"estimatedAnnualVolume"`
+	StreetName                     string `json:"streetName"`
+	BuildingNumber                 string `json:"buildingNumber"`
+	FloorId                        string `json:"floorId"`
+	RoomId                         string `json:"roomId"`
+	Postcode                       string `json:"postcode"`
+	CityName                       string `json:"cityName"`
+	CitySubDivisionName            string `json:"citySubDivisionName"`
+	MunicipalityCode               string `json:"municipalityCode"`
+	FirstConsumerPartyName         string `json:"firstConsumerPartyName"`
+	SecondConsumerPartyName        string `json:"secondConsumerPartyName"`
+}
+
+// MeteringPointDetails is the data format returned from eloverblik, containing metering point details.
+type MeteringPointDetails struct {
+	Result []struct {
+		Result        MeteringPoint `json:"result"`
+		Success       bool          `json:"success"`
+		ErrorCode     int           `json:"errorCode"`
+		ErrorCodeEnum string        `json:"errorCodeEnum"`
+		ErrorText     string        `json:"errorText"`
+		Id            string        `json:"id"`
+		StackTrace    interface{}   `json:"stackTrace"`
+	} `json:"result"`
+}
+
+var meterTypes = map[string]string{
+	"E17": "consumption",
+	"E18": "production",
+	"E20": "exchange",
+	"D01": "VE production",
+	"D02": "analysis",
+	"D06": "supply to grid",
+	"D07": "consumption from grid",
+	"D12": "total consumption",
+	"D14": "electrical heating",
+	"D15": "net consumption",
+	"D20": "own production",
+	"D99": "internal use",
+}
+
+var settlementMethods = map[string]string{
+	"D01": "flex settled",
+	"E01": "profiled",
+	"E02": "non-profiled (hourly)",
+}
+
+// MeterType returns a description of the type of mp
+func (mp MeteringPoint) MeterType() string {
+	if t, ok := meterTypes[mp.TypeOfMP]; ok {
+		return t
+	}
+	return mp.TypeOfMP
+}
+
+// Settlement returns a description of the settlement method of mp
+func (mp MeteringPoint) Settlement() string {
+	if s, ok := settlementMethods[mp.SettlementMethod]; ok {
+		return s
+	}
+	return mp.SettlementMethod
+}
+
+// Address returns the address of mp as a single line
+func (mp MeteringPoint) Address() string {
+	street := strings.TrimSpace(mp.StreetName + " " + mp.BuildingNumber)
+	if floor := strings.TrimSpace(mp.FloorId + " " + mp.RoomId); floor != "" {
+		street += ", " + floor
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s, %s %s", street, mp.Postcode, mp.CityName))
+}
+
+// PriceArea maps mp to the spot price area it belongs to. Postcodes below 5000
+// cover Zealand, the islands south of it and Bornholm, which are all east of
+// Storebælt. If the postcode is missing, the grid area is used instead, where
+// the eastern grid areas are numbered from 700 and up.
+func (mp MeteringPoint) PriceArea() (energidataservice.Area, error) {
+	if pc, err := strconv.Atoi(mp.Postcode); err == nil && pc >= 1000 && pc <= 9999 {
+		if pc < 5000 {
+			return energidataservice.AreaDKEast, nil
+		}
+		return energidataservice.AreaDKWest, nil
+	}
+	if ga, err := strconv.Atoi(mp.MeteringGridAreaIdentification); err == nil && ga > 0 {
+		if ga >= 700 {
+			return energidataservice.AreaDKEast, nil
+		}
+		return energidataservice.AreaDKWest, nil
+	}
+	return "", fmt.Errorf("can't determine price area for metering point %s", mp.MeteringPointId)
+}
+
+// Details fetches the details of the configured metering point
+func (e *Eloverblik) Details(ctx context.Context) (MeteringPoint, error) {
+	var d MeteringPointDetails
+	if err := e.withAuth(ctx, func(token []byte) error {
+		response, err := postMeteringPoint(ctx, transport.Client(e.client), e.baseURL(), "/meteringpoints/meteringpoint/getdetails", token, e.mid)
+		if err != nil {
+			return err
+		}
+		d = MeteringPointDetails{}
+		return json.Unmarshal(response, &d)
+	}); err != nil {
+		return MeteringPoint{}, err
+	}
+	return d.MeteringPoint()
+}
+
+// MeteringPoint returns the first metering point in d
+func (d MeteringPointDetails) MeteringPoint() (MeteringPoint, error) {
+	if len(d.Result) == 0 {
+		return MeteringPoint{}, ErrNoDetails
+	}
+	if !d.Result[0].Success {
+		return MeteringPoint{}, fmt.Errorf("eloverblik error %d: %s", d.Result[0].ErrorCode, d.Result[0].ErrorText)
+	}
+	return d.Result[0].Result, nil
+}
+
+// FetchDetails gets the details of the metering point in c
+func FetchDetails(ctx context.Context, c interfaces.Configurator) (MeteringPoint, error) {
+	return FromConfig(c).Details(ctx)
+}
+
+// DetectArea finds the price area of the metering point in c
+func DetectArea(ctx context.Context, c interfaces.Configurator) (energidataservice.Area, error) {
+	mp, err := FetchDetails(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	return mp.PriceArea()
+}
@@ -0,0 +1,67 @@
+package eloverblik_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/cache"
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/adamhassel/power/repos/eloverblik"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreloadTariffs(t *testing.T) {
+	up := fakeupstream.New(t)
+	defer func(u string, c *cache.Cache[string, eloverblik.FullTariffs]) {
+		eloverblik.DefaultURL, eloverblik.FullTariffsCached = u, c
+	}(eloverblik.DefaultURL, eloverblik.FullTariffsCached)
+	eloverblik.DefaultURL = up.Eloverblik.URL
+	eloverblik.FullTariffsCached = cache.New[string, eloverblik.FullTariffs]()
+	ctx := context.Background()
+
+	require.NoError(t, eloverblik.PreloadTariffs(ctx, fakeupstream.Config{Key: fakeupstream.Token}))
+	assert.Equal(t, 1, up.Requests("/meteringpoints/meteringpoint/getcharges"))
+	ft := eloverblik.CachedTariffs(fakeupstream.MID)
+	assert.False(t, ft.UpdatedAt().IsZero())
+	noon := ft.Index().At(time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location))
+	assert.InDelta(t, 0.25+0.72+0.051, noon.Taxes().Total(), 1e-9)
+	require.Len(t, ft.Charges(), 1)
+	assert.Equal(t, 54.0, ft.Charges()[0].Price)
+
+	// without a valid token, the tariffs already loaded are kept
+	assert.Error(t, eloverblik.PreloadTariffs(ctx, fakeupstream.Config{Key: "not-a-token"}))
+	assert.Equal(t, ft, eloverblik.CachedTariffs(fakeupstream.MID))
+
+	a, err := eloverblik.DetectArea(ctx, fakeupstream.Config{Key: fakeupstream.Token})
+	require.NoError(t, err)
+	assert.Equal(t, energidataservice.AreaDKWest, a)
+}
+
+func TestEloverblik_Auth(t *testing.T) {
+	up := fakeupstream.New(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	e := up.Tariffs(fakeupstream.Config{Key: "not-a-token"})
+	_, err := e.Tariffs(ctx, now, now)
+	assert.Error(t, err)
+	assert.Equal(t, 1, up.Requests("/token"))
+	assert.Zero(t, up.Requests("/meteringpoints/meteringpoint/getcharges"))
+
+	e = up.Tariffs(fakeupstream.Config{Key: fakeupstream.Token})
+	_, err = e.Tariffs(ctx, now, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, up.Requests("/token"))
+
+	// an expired data access token is renewed
+	up.Expire()
+	mp, err := e.Details(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "8000", mp.Postcode)
+	assert.Equal(t, 3, up.Requests("/token"))
+	assert.Equal(t, 2, up.Requests("/meteringpoints/meteringpoint/getdetails"))
+}
@@ -0,0 +1,89 @@
+package energidataservice
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+)
+
+// Dataset is a dataset on energidataservice containing spot prices
+type Dataset string
+
+const (
+	// DatasetElspotprices has hourly prices, until the day-ahead market moved to 15 minute resolution
+	DatasetElspotprices Dataset = "elspotprices"
+	// DatasetDayAheadPrices has prices in 15 minute resolution
+	DatasetDayAheadPrices Dataset = "DayAheadPrices"
+)
+
+// dayAheadCutover is when the day-ahead market moved to 15 minute resolution
+var dayAheadCutover = time.Date(2025, 9, 30, 22, 0, 0, 0, time.UTC)
+
+// dayAheadPrice is a record from the DayAheadPrices dataset
+type dayAheadPrice struct {
+	TimeUTC          entities.PTime `json:"TimeUTC"`
+	TimeDK           entities.PTime `json:"TimeDK"`
+	PriceArea        string         `json:"PriceArea"`
+	DayAheadPriceDKK *float64       `json:"DayAheadPriceDKK"`
+	DayAheadPriceEUR float64        `json:"DayAheadPriceEUR"`
+}
+
+// sortField is the field records in d are sorted by
+func (d Dataset) sortField() string {
+	if d == DatasetDayAheadPrices {
+		return "TimeUTC"
+	}
+	return "HourUTC"
+}
+
+// decode parses the records in raw, in the format of d
+func (d Dataset) decode(raw json.RawMessage) ([]entities.Elspotprice, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if d != DatasetDayAheadPrices {
+		var rv []entities.Elspotprice
+		err := json.Unmarshal(raw, &rv)
+		return rv, err
+	}
+	var records []dayAheadPrice
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return nil, err
+	}
+	rv := make([]entities.Elspotprice, len(records))
+	for i, r := range records {
+		rv[i] = entities.Elspotprice{
+			HourUTC:      r.TimeUTC,
+			HourDK:       r.TimeDK,
+			PriceArea:    r.PriceArea,
+			SpotPriceDKK: r.DayAheadPriceDKK,
+			SpotPriceEUR: r.DayAheadPriceEUR,
+			Resolution:   15 * time.Minute,
+		}
+	}
+	return rv, nil
+}
+
+// datasetRange is a period to fetch from a dataset
+type datasetRange struct {
+	dataset  Dataset
+	from, to time.Time
+}
+
+// datasetRanges splits from - to in the periods covered by each dataset. If d
+// is set, the whole period is fetched from that.
+func datasetRanges(from, to time.Time, d Dataset) []datasetRange {
+	switch {
+	case d != "":
+		return []datasetRange{{d, from, to}}
+	case !to.After(dayAheadCutover):
+		return []datasetRange{{DatasetElspotprices, from, to}}
+	case !from.Before(dayAheadCutover):
+		return []datasetRange{{DatasetDayAheadPrices, from, to}}
+	}
+	return []datasetRange{
+		{DatasetElspotprices, from, dayAheadCutover},
+		{DatasetDayAheadPrices, dayAheadCutover, to},
+	}
+}
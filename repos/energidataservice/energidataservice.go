@@ -1,46 +1,67 @@
 package energidataservice
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
+	"strings"
 	"time"
 
 	"github.com/adamhassel/power/entities"
-	"github.com/rickar/cal/v2"
-	"github.com/rickar/cal/v2/dk"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/logging"
+	"github.com/adamhassel/power/metrics"
+	"github.com/adamhassel/power/repos/nationalbanken"
+	"github.com/adamhassel/power/transport"
 )
 
-const dataServiceUrl = "https://api.energidataservice.dk/dataset/elspotprices"
+// DefaultURL is the base URL of the datasets on energidataservice, unless one
+// is set with URL
+var DefaultURL = "https://api.energidataservice.dk/dataset"
 
 //const queryTemplate = `{"operationName":"Dataset","variables":{},"query":"query Dataset {\n  elspotprices(\n    where: {HourDK: {_gte: \"%s\", _lt: \"%s\"}, PriceArea: {_eq: \"%s\"}}\n    order_by: {HourUTC: asc}\n    limit: %d\n    offset: %d\n  ) {\n    HourUTC\n    HourDK\n    PriceArea\n    SpotPriceDKK\n    SpotPriceEUR\n    __typename\n  }\n}\n"}`
-const queryTemplate = `start=%s&end=%s&filter={"PriceArea":"%s"}&limit=%d&offset=%d&sort=HourUTC`
+const queryTemplate = `start=%s&end=%s&filter={"PriceArea":"%s"}&limit=%d&offset=%d&sort=%s`
 
-type area string
+// Area is a price area
+type Area string
 
 const (
 	// AreaDKWest is for anyone living west of Storebælt
-	AreaDKWest area = "DK1"
+	AreaDKWest Area = "DK1"
 	// AreaDKEast is for anyone living east of Storebælt
-	AreaDKEast area = "DK2"
+	AreaDKEast Area = "DK2"
 )
 
-const (
-	defaultLimit  = 100
-	defaultOffset = 0
-)
+// ParseArea returns the price area named s. An empty s yields AreaDKEast.
+func ParseArea(s string) (Area, error) {
+	switch a := Area(strings.ToUpper(s)); a {
+	case "":
+		return AreaDKEast, nil
+	case AreaDKWest, AreaDKEast:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown price area '%s', must be %s or %s", s, AreaDKWest, AreaDKEast)
+}
 
+// defaultLimit is the number of records fetched per page, unless another is
+// set with PageSize
+const defaultLimit = 1000
+
+// EnergiDataService fetches spot prices. It implements interfaces.SpotPriceProvider.
 type EnergiDataService struct {
-	area     area
-	from, to time.Time
-	p        Prices
+	dataset Dataset
+	rates   interfaces.ExchangeRateProvider
+	client  *http.Client
+	url     string
+	limit   int
 }
 
 // Prices is the data returned from  energidataservice, containing raw power prices
 type Prices struct {
+	Total        int                    `json:"total"`
 	Elspotprices []entities.Elspotprice `json:"records"`
 }
 
@@ -48,137 +69,162 @@ func (p Prices) SpotPrices() []entities.Elspotprice {
 	return p.Elspotprices
 }
 
-func (e *EnergiDataService) Area(a area) {
-	e.area = a
+// Dataset sets the dataset to fetch prices from. If not set, prices before the
+// move to 15 minute resolution are fetched from DatasetElspotprices, and the
+// rest from DatasetDayAheadPrices.
+func (e *EnergiDataService) Dataset(d Dataset) {
+	e.dataset = d
+}
+
+// Rates sets the exchange rate provider used to estimate DKK prices when only
+// EUR prices are available. Default is Nationalbanken.
+func (e *EnergiDataService) Rates(r interfaces.ExchangeRateProvider) {
+	e.rates = r
 }
 
-func (e *EnergiDataService) Timer(from, to time.Time) {
-	e.from = from.Truncate(time.Hour)
-	e.to = to.Truncate(time.Hour)
+// Client sets the HTTP client used for requests. Default is transport.Default.
+func (e *EnergiDataService) Client(c *http.Client) {
+	e.client = c
 }
 
-func (e *EnergiDataService) Query() (interface{}, error) {
-	e.p = Prices{}
-	if e.from.IsZero() {
-		e.from = time.Now().Truncate(24 * time.Hour)
+// URL sets the base URL of the datasets. Default is DefaultURL.
+func (e *EnergiDataService) URL(u string) {
+	e.url = u
+}
+
+// PageSize sets the number of records fetched per request. Default is 1000.
+func (e *EnergiDataService) PageSize(n int) {
+	e.limit = n
+}
+
+// pageSize returns the number of records to fetch per request
+func (e *EnergiDataService) pageSize() int {
+	if e.limit > 0 {
+		return e.limit
 	}
-	if e.to.IsZero() || e.to.Before(e.from) {
-		e.to = e.from.Add(48 * time.Hour)
+	return defaultLimit
+}
+
+// baseURL returns the base URL to fetch prices from
+func (e *EnergiDataService) baseURL() string {
+	if e.url != "" {
+		return e.url
 	}
-	if err := e.p.query(e.from, e.to, e.area); err != nil {
+	return DefaultURL
+}
+
+// SpotPrices fetches spot prices in area from `from` to `to`, estimating any
+// missing DKK prices from the exchange rates
+func (e *EnergiDataService) SpotPrices(ctx context.Context, from, to time.Time, area string) ([]entities.Elspotprice, error) {
+	a, err := ParseArea(area)
+	if err != nil {
+		return nil, err
+	}
+	rates := e.rates
+	if rates == nil {
+		rates = nationalbanken.Default
+	}
+	var p Prices
+	if err := p.query(ctx, transport.Client(e.client), e.baseURL(), from.Truncate(time.Hour), to.Truncate(time.Hour), a, e.dataset, e.pageSize()); err != nil {
 		return nil, err
 	}
-	return e.p, nil
+	if err := p.FixupDKK(ctx, rates); err != nil {
+		return nil, err
+	}
+	return p.Elspotprices, nil
 }
 
-func (p *Prices) query(from, to time.Time, a area) error {
-	if err := p.getRawSpotPrices(from, to, a); err != nil {
-		return err
+func (p *Prices) query(ctx context.Context, client *http.Client, base string, from, to time.Time, a Area, d Dataset, limit int) error {
+	p.Elspotprices = nil
+	for _, dr := range datasetRanges(from, to, d) {
+		var part Prices
+		if err := part.getRawSpotPrices(ctx, client, base, dr.from, dr.to, a, dr.dataset, limit); err != nil {
+			return err
+		}
+		p.Total += part.Total
+		p.Elspotprices = append(p.Elspotprices, part.Elspotprices...)
+	}
+	return nil
+}
+
+// getRawSpotPrices fetches all records from `from` to `to`, a page of at most limit records at a time
+func (p *Prices) getRawSpotPrices(ctx context.Context, client *http.Client, base string, from, to time.Time, a Area, d Dataset, limit int) error {
+	p.Elspotprices = nil
+	for offset := 0; ; {
+		var page Prices
+		if err := page.getPage(ctx, client, base, from, to, a, d, limit, offset); err != nil {
+			return err
+		}
+		p.Total = page.Total
+		p.Elspotprices = append(p.Elspotprices, page.Elspotprices...)
+		offset += len(page.Elspotprices)
+		if len(page.Elspotprices) == 0 || offset >= page.Total {
+			return nil
+		}
 	}
-	return p.fixupDKK(a)
 }
 
-func (p *Prices) getRawSpotPrices(from, to time.Time, a area) error {
-	params := makeSpotPriceQuery(from, to, a)
-	u := dataServiceUrl + "?" + params
-	req, err := http.NewRequest(http.MethodGet, u, nil)
+// getPage fetches a single page of at most limit records, starting at offset
+func (p *Prices) getPage(ctx context.Context, client *http.Client, base string, from, to time.Time, a Area, d Dataset, limit, offset int) (err error) {
+	defer metrics.ObserveUpstream(metrics.Energidataservice, time.Now(), &err)
+	params := makeSpotPriceQuery(from, to, a, d, limit, offset)
+	u := strings.TrimSuffix(base, "/") + "/" + string(d) + "?" + params
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return err
 	}
-	out, _ := httputil.DumpRequest(req, true)
-	fmt.Println(string(out))
 	req.Header.Add("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	slog.DebugContext(ctx, "energidataservice response", "url", u, "status", resp.StatusCode, "body", logging.Body(response))
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("energiDataService returned %s, '%s'", resp.Status, response)
 	}
 
-	return json.Unmarshal(response, &p)
+	var page struct {
+		Total   int             `json:"total"`
+		Records json.RawMessage `json:"records"`
+	}
+	if err := json.Unmarshal(response, &page); err != nil {
+		return err
+	}
+	p.Total = page.Total
+	p.Elspotprices, err = d.decode(page.Records)
+	return err
 }
 
-// on weekends, there are no DKK prices, since there are no exchange rates. After
+// FixupDKK fills in missing DKK prices, using the exchange rates from r. On
+// weekends, there are no DKK prices, since there are no exchange rates. After
 // the weekend, they're set retroactively. But we don't have future vision, so
-// for any prices with only a euro price, we'll use the last record with DKK and
-// EUR from before the weekend, and derive an exchange rate from that, which
-// we'll use.
-func (p *Prices) fixupDKK(a area) error {
-	// find out if there are any missing DKK..
-	var latestEUR, latestDKK float64
-	emptyDKK := false
-	for _, sp := range p.Elspotprices {
-		if sp.SpotPriceDKK == nil {
-			emptyDKK = true
-		} else if *sp.SpotPriceDKK != 0 && sp.SpotPriceEUR != 0 {
-			latestEUR = sp.SpotPriceEUR
-			latestDKK = *sp.SpotPriceDKK
+// for any prices with only a euro price, we'll use the latest exchange rate
+// published before it, and mark the DKK price as estimated.
+func (p *Prices) FixupDKK(ctx context.Context, r interfaces.ExchangeRateProvider) error {
+	for i, price := range p.Elspotprices {
+		if price.SpotPriceDKK != nil {
+			continue
 		}
-	}
-	// All DKK prices filled,
-	if !emptyDKK {
-		return nil
-	}
-
-	// if we already have prices (like, a friday), use that
-	if latestEUR == 0 && latestDKK == 0 {
-		// We know the first price is the earliest, since that's how it's sorted
-		earliest := p.Elspotprices[0].HourUTC
-
-		var target time.Time
-		// Check if we're a non-working day, and find Previsou workday @ 2300 local
-		c := cal.NewBusinessCalendar()
-		c.Calendar.AddHoliday(dk.Holidays...)
-		target = prevWorkDay(c, time.Time(earliest))
-		// fetch the last price of the workday, set time to 2300 local
-		target = time.Date(target.Year(), target.Month(), target.Day(), 23, 0, 0, 0, time.Time(earliest).Location())
-		var rateprice Prices
-		if err := rateprice.getRawSpotPrices(target, target.Add(time.Hour), a); err != nil {
+		rate, err := r.Rate(ctx, "EUR", time.Time(price.HourUTC))
+		if err != nil {
 			return err
 		}
-		if rateprice.Elspotprices[0].SpotPriceDKK == nil {
-			return errors.New("DKK price was nil")
-		}
-		latestDKK = *rateprice.Elspotprices[0].SpotPriceDKK
-		latestEUR = rateprice.Elspotprices[0].SpotPriceEUR
-	}
-
-	rate := latestDKK / latestEUR
-
-	// finally, fixup prices where appropriate
-	for i, price := range p.Elspotprices {
-		if price.SpotPriceDKK == nil {
-			p.Elspotprices[i].SpotPriceDKK = new(float64)
-			*p.Elspotprices[i].SpotPriceDKK = price.SpotPriceEUR * rate
-			p.Elspotprices[i].DKKEstimated = true
-			p.Elspotprices[i].EstimatedRate = rate
-		}
+		p.Elspotprices[i].SpotPriceDKK = new(float64)
+		*p.Elspotprices[i].SpotPriceDKK = price.SpotPriceEUR * rate
+		p.Elspotprices[i].DKKEstimated = true
+		p.Elspotprices[i].EstimatedRate = rate
 	}
 	return nil
 }
 
-func prevWorkDay(c *cal.BusinessCalendar, date time.Time) time.Time {
-	t := date
-	if date.Before(c.WorkdayStart(date)) {
-		t = t.Add(-24 * time.Hour)
-	}
-
-	for !c.IsWorkday(t) {
-		t = t.Add(-24 * time.Hour)
-	}
-	return c.WorkdayStart(t)
-}
-
-func makeSpotPriceQuery(start, end time.Time, a area) string {
+func makeSpotPriceQuery(start, end time.Time, a Area, d Dataset, limit, offset int) string {
 	if a == "" {
 		a = AreaDKEast
 	}
-	return fmt.Sprintf(queryTemplate, start.Local().Format("2006-01-02T15:04"), end.Local().Format("2006-01-02T15:04"), a, defaultLimit, defaultOffset)
+	return fmt.Sprintf(queryTemplate, start.In(entities.Location).Format("2006-01-02T15:04"), end.In(entities.Location).Format("2006-01-02T15:04"), a, limit, offset, d.sortField())
 }
@@ -0,0 +1,79 @@
+package energidataservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedRate float64
+
+func (f fixedRate) Rate(context.Context, string, time.Time) (float64, error) {
+	return float64(f), nil
+}
+
+func TestPrices_FixupDKK(t *testing.T) {
+	dkk := 745.0
+	p := Prices{Elspotprices: []entities.Elspotprice{
+		{SpotPriceDKK: &dkk, SpotPriceEUR: 100},
+		{SpotPriceEUR: 200},
+	}}
+	require.NoError(t, p.FixupDKK(context.Background(), fixedRate(7.5)))
+
+	assert.False(t, p.Elspotprices[0].DKKEstimated)
+	assert.Equal(t, 745.0, *p.Elspotprices[0].SpotPriceDKK)
+
+	assert.True(t, p.Elspotprices[1].DKKEstimated)
+	assert.Equal(t, 7.5, p.Elspotprices[1].EstimatedRate)
+	assert.Equal(t, 1500.0, *p.Elspotprices[1].SpotPriceDKK)
+}
+
+func TestEnergiDataService_SpotPricesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var e EnergiDataService
+	e.Rates(fixedRate(7.5))
+	_, err := e.SpotPrices(ctx, time.Now(), time.Now().Add(time.Hour), "DK1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_makeSpotPriceQuery(t *testing.T) {
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	got := makeSpotPriceQuery(start, start.Add(24*time.Hour), AreaDKWest, DatasetElspotprices, 100, 200)
+	assert.Equal(t, `start=2022-02-01T00:00&end=2022-02-02T00:00&filter={"PriceArea":"DK1"}&limit=100&offset=200&sort=HourUTC`, got)
+	got = makeSpotPriceQuery(start, start.Add(24*time.Hour), AreaDKEast, DatasetDayAheadPrices, 100, 0)
+	assert.Equal(t, `start=2022-02-01T00:00&end=2022-02-02T00:00&filter={"PriceArea":"DK2"}&limit=100&offset=0&sort=TimeUTC`, got)
+}
+
+func TestDataset_decode(t *testing.T) {
+	raw := `[{"TimeUTC":"2025-10-01T22:00:00","TimeDK":"2025-10-02T00:00:00","PriceArea":"DK1","DayAheadPriceEUR":100.5,"DayAheadPriceDKK":750.1},
+{"TimeUTC":"2025-10-01T22:15:00","TimeDK":"2025-10-02T00:15:00","PriceArea":"DK1","DayAheadPriceEUR":90.0,"DayAheadPriceDKK":null}]`
+	got, err := DatasetDayAheadPrices.decode([]byte(raw))
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, time.Date(2025, 10, 1, 22, 15, 0, 0, time.UTC), time.Time(got[1].HourUTC))
+	assert.Equal(t, 15*time.Minute, got[0].Duration())
+	assert.Equal(t, 750.1, *got[0].SpotPriceDKK)
+	assert.Nil(t, got[1].SpotPriceDKK)
+	assert.Equal(t, 90.0, got[1].SpotPriceEUR)
+
+	got, err = DatasetElspotprices.decode([]byte(`[{"HourUTC":"2022-02-01T22:00:00","PriceArea":"DK1","SpotPriceEUR":100,"SpotPriceDKK":744}]`))
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, got[0].Duration())
+}
+
+func Test_datasetRanges(t *testing.T) {
+	before := dayAheadCutover.Add(-24 * time.Hour)
+	after := dayAheadCutover.Add(24 * time.Hour)
+	assert.Equal(t, []datasetRange{{DatasetElspotprices, before, dayAheadCutover}}, datasetRanges(before, dayAheadCutover, ""))
+	assert.Equal(t, []datasetRange{{DatasetDayAheadPrices, dayAheadCutover, after}}, datasetRanges(dayAheadCutover, after, ""))
+	assert.Equal(t, []datasetRange{
+		{DatasetElspotprices, before, dayAheadCutover},
+		{DatasetDayAheadPrices, dayAheadCutover, after},
+	}, datasetRanges(before, after, ""))
+	assert.Equal(t, []datasetRange{{DatasetElspotprices, before, after}}, datasetRanges(before, after, DatasetElspotprices))
+}
@@ -0,0 +1,86 @@
+package energidataservice_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnergiDataService_SpotPricesWeekend(t *testing.T) {
+	up := fakeupstream.New(t)
+	// Friday noon to Saturday noon, before the move to 15 minutes
+	from := time.Date(2025, 3, 7, 12, 0, 0, 0, entities.Location)
+	ps, err := up.SpotPrices().SpotPrices(context.Background(), from, from.Add(24*time.Hour), "DK1")
+	require.NoError(t, err)
+	require.Len(t, ps, 24)
+	for i, p := range ps {
+		assert.Equal(t, time.Hour, p.Duration())
+		// there are no DKK prices on Saturday, so they're estimated with Friday's rate
+		assert.Equal(t, i >= 12, p.DKKEstimated, i)
+		require.NotNil(t, p.SpotPriceDKK)
+	}
+	assert.InDelta(t, 7.4605*64.8, *ps[0].SpotPriceDKK, 1e-9)
+	assert.InDelta(t, 7.4602, ps[12].EstimatedRate, 1e-9)
+	assert.InDelta(t, 7.4602*58.1, *ps[12].SpotPriceDKK, 1e-9)
+	assert.Equal(t, 1, up.Requests("/elspotprices"))
+	assert.Equal(t, 1, up.Requests("/CurrencyRatesHistoryXML"))
+}
+
+func TestEnergiDataService_SpotPricesPartialDay(t *testing.T) {
+	up := fakeupstream.New(t)
+	// tomorrow's prices aren't published yet, so there are only prices until midnight
+	from := time.Date(2025, 10, 6, 10, 0, 0, 0, entities.Location)
+	ps, err := up.SpotPrices().SpotPrices(context.Background(), from, from.Add(24*time.Hour), "DK2")
+	require.NoError(t, err)
+	require.Len(t, ps, 56)
+	assert.True(t, time.Time(ps[0].HourUTC).Equal(from))
+	assert.True(t, time.Time(ps[55].HourUTC).Equal(time.Date(2025, 10, 6, 23, 45, 0, 0, entities.Location)))
+	for _, p := range ps {
+		assert.Equal(t, 15*time.Minute, p.Duration())
+		assert.Equal(t, "DK2", p.PriceArea)
+		assert.False(t, p.DKKEstimated)
+	}
+	assert.Equal(t, 77.7, ps[0].SpotPriceEUR)
+	assert.Equal(t, 0, up.Requests("/elspotprices"))
+	// no rates are needed when all prices are in DKK
+	assert.Equal(t, 0, up.Requests("/CurrencyRatesXML"))
+}
+
+func TestEnergiDataService_SpotPricesPaged(t *testing.T) {
+	up := fakeupstream.New(t)
+	e := up.SpotPrices()
+	e.PageSize(10)
+	// from the hourly prices in March, across the move to 15 minutes, to the end of October 6th
+	from := time.Date(2025, 3, 7, 0, 0, 0, 0, entities.Location)
+	to := time.Date(2025, 10, 7, 0, 0, 0, 0, entities.Location)
+	ps, err := e.SpotPrices(context.Background(), from, to, "DK1")
+	require.NoError(t, err)
+	require.Len(t, ps, 72+96)
+
+	// each dataset is fetched a page at a time, until all its records are in
+	for path, pages := range map[string]int{"/elspotprices": 8, "/DayAheadPrices": 10} {
+		qs := up.Queries(path)
+		require.Len(t, qs, pages, path)
+		for i, q := range qs {
+			assert.Equal(t, "10", q.Get("limit"), path)
+			assert.Equal(t, strconv.Itoa(10*i), q.Get("offset"), path)
+		}
+	}
+
+	// with no gaps or duplicates
+	for i, p := range ps[1:] {
+		prev := ps[i]
+		if i == 71 {
+			assert.Equal(t, time.Hour, prev.Duration())
+			assert.True(t, time.Time(p.HourUTC).Equal(time.Date(2025, 10, 6, 0, 0, 0, 0, entities.Location)))
+			continue
+		}
+		assert.True(t, time.Time(p.HourUTC).Equal(time.Time(prev.HourUTC).Add(prev.Duration())), i)
+	}
+}
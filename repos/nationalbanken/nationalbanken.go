@@ -0,0 +1,236 @@
+// Package nationalbanken provides exchange rates from Danmarks Nationalbank.
+// See entities/exchange.go for the format of the data.
+package nationalbanken
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/metrics"
+	"github.com/adamhassel/power/transport"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultURL is the base URL of Nationalbanken's data service, unless one is
+// set with URL
+var DefaultURL = "https://www.nationalbanken.dk/_vti_bin/DN/DataService.svc"
+
+// Paths of the current rates and the recent history below the base URL
+const (
+	ratesPath   = "/CurrencyRatesXML?lang=en"
+	historyPath = "/CurrencyRatesHistoryXML?lang=en"
+)
+
+// CentralParity is the central rate of DKK per EUR in ERM II. The krone is
+// kept very close to it, so it's a good estimate of rates that can't be
+// fetched.
+const CentralParity = 7.46038
+
+// refetchInterval is how long to wait between fetching rates, when a rate is missing
+const refetchInterval = time.Hour
+
+// lookback is how many days back to look for a rate, to get across weekends and holidays
+const lookback = 7
+
+const dateFormat = "2006-01-02"
+
+// Default is the default exchange rate provider
+var Default = &Nationalbanken{}
+
+// Nationalbanken fetches and caches daily exchange rates. The zero value is ready to use.
+type Nationalbanken struct {
+	mu      sync.Mutex
+	client  *http.Client
+	url     string
+	now     func() time.Time
+	rates   map[string]map[string]float64 // date -> currency -> DKK per unit
+	fetched time.Time
+	flight  singleflight.Group
+}
+
+// exchangeRates is the XML format returned from Nationalbanken. Rates are DKK per 100 units.
+type exchangeRates struct {
+	XMLName    xml.Name `xml:"exchangerates"`
+	DailyRates []struct {
+		ID         string `xml:"id,attr"`
+		Currencies []struct {
+			Code string `xml:"code,attr"`
+			Desc string `xml:"desc,attr"`
+			Rate string `xml:"rate,attr"`
+		} `xml:"currency"`
+	} `xml:"dailyrates"`
+}
+
+// Client sets the HTTP client used for requests. Default is transport.Default.
+func (n *Nationalbanken) Client(c *http.Client) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.client = c
+}
+
+// URL sets the base URL of the data service. Default is DefaultURL.
+func (n *Nationalbanken) URL(u string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.url = u
+}
+
+// Clock sets the function returning the current time. Default is time.Now.
+func (n *Nationalbanken) Clock(now func() time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.now = now
+}
+
+// clock returns the current time. n.mu must be held.
+func (n *Nationalbanken) clock() time.Time {
+	if n.now == nil {
+		return time.Now()
+	}
+	return n.now()
+}
+
+// Rate returns the rate in DKK per unit of currency at t. Rates are only
+// published on banking days, so the latest rate published on or before t's
+// date is used. Only the rates of the last few banking days are published,
+// so older rates are only found if they were fetched back then. If no rate can
+// be found for EUR, the central parity is returned.
+func (n *Nationalbanken) Rate(ctx context.Context, currency string, t time.Time) (float64, error) {
+	currency = strings.ToUpper(currency)
+	n.mu.Lock()
+	rate, exact := n.lookup(currency, t)
+	now := n.clock()
+	refetch := now.Sub(n.fetched) > refetchInterval
+	n.mu.Unlock()
+	if exact || (rate != 0 && t.Before(now.Truncate(24*time.Hour))) {
+		return rate, nil
+	}
+	var err error
+	if refetch {
+		if err = n.refresh(ctx); ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		n.mu.Lock()
+		rate, _ = n.lookup(currency, t)
+		n.mu.Unlock()
+	}
+	if rate != 0 {
+		return rate, nil
+	}
+	if currency == "EUR" {
+		return CentralParity, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no exchange rate for %s at %s", currency, t.Format(dateFormat))
+}
+
+// refresh fetches the rates, without holding the lock meanwhile, and adds them
+// to the cache. Refreshes while it's fetching share the fetch, which isn't
+// cancelled with ctx, since others may be waiting for it. refresh returns when
+// it's done, or ctx is.
+func (n *Nationalbanken) refresh(ctx context.Context) error {
+	ch := n.flight.DoChan("rates", func() (interface{}, error) {
+		n.mu.Lock()
+		client, base := n.client, n.url
+		n.mu.Unlock()
+
+		rates, err := fetch(context.WithoutCancel(ctx), client, base)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.fetched = n.clock()
+		if n.rates == nil {
+			n.rates = make(map[string]map[string]float64, len(rates))
+		}
+		for day, r := range rates {
+			n.rates[day] = r
+		}
+		return nil, err
+	})
+	select {
+	case r := <-ch:
+		return r.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lookup finds the latest cached rate for currency on or before t's date. exact
+// is true if it's from that very date.
+func (n *Nationalbanken) lookup(currency string, t time.Time) (rate float64, exact bool) {
+	t = t.In(entities.Location)
+	for i := 0; i < lookback; i++ {
+		if r, ok := n.rates[t.AddDate(0, 0, -i).Format(dateFormat)][currency]; ok {
+			return r, i == 0
+		}
+	}
+	return 0, false
+}
+
+// fetch gets the recent history and the current rates from the data service at
+// base, with client
+func fetch(ctx context.Context, client *http.Client, base string) (map[string]map[string]float64, error) {
+	if base == "" {
+		base = DefaultURL
+	}
+	base = strings.TrimSuffix(base, "/")
+	rates := make(map[string]map[string]float64)
+	var rv error
+	for _, path := range []string{historyPath, ratesPath} {
+		if err := get(ctx, client, base+path, rates); err != nil {
+			rv = err
+		}
+	}
+	return rates, rv
+}
+
+// get fetches rates from u, and adds them to rates
+func get(ctx context.Context, client *http.Client, u string, rates map[string]map[string]float64) (err error) {
+	defer metrics.ObserveUpstream(metrics.Nationalbanken, time.Now(), &err)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := transport.Client(client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("nationalbanken returned %s", resp.Status)
+	}
+	return parse(resp.Body, rates)
+}
+
+// parse reads rates in XML from r, and adds them to rates
+func parse(r io.Reader, rates map[string]map[string]float64) error {
+	var er exchangeRates
+	if err := xml.NewDecoder(r).Decode(&er); err != nil {
+		return err
+	}
+	for _, d := range er.DailyRates {
+		if _, err := time.Parse(dateFormat, d.ID); err != nil {
+			return err
+		}
+		day := make(map[string]float64, len(d.Currencies))
+		for _, c := range d.Currencies {
+			rate, err := strconv.ParseFloat(c.Rate, 64)
+			if err != nil {
+				// some currencies are listed without a rate
+				continue
+			}
+			day[c.Code] = rate / 100
+		}
+		rates[d.ID] = day
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package nationalbanken
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const history = `<?xml version="1.0" encoding="utf-8"?>
+<exchangerates xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" type="Exchange rates" author="Danmarks Nationalbank" refcur="DKK" refamt="1">
+<dailyrates id="2022-02-07">
+<currency code="EUR" desc="Euro" rate="744.43"/>
+<currency code="USD" desc="US dollars" rate="650.33"/>
+</dailyrates>
+<dailyrates id="2022-02-04">
+<currency code="EUR" desc="Euro" rate="744.38"/>
+<currency code="USD" desc="US dollars" rate="651.20"/>
+<currency code="RUB" desc="Russian rouble" rate="-"/>
+</dailyrates>
+</exchangerates>`
+
+func TestNationalbanken_Rate(t *testing.T) {
+	n := Nationalbanken{rates: make(map[string]map[string]float64)}
+	require.NoError(t, parse(strings.NewReader(history), n.rates))
+	now := time.Date(2022, 2, 8, 10, 0, 0, 0, entities.Location)
+	n.Clock(func() time.Time { return now })
+	// don't go fetching anything
+	n.fetched = now
+
+	rate, err := n.Rate(context.Background(), "EUR", time.Date(2022, 2, 7, 12, 0, 0, 0, entities.Location))
+	assert.NoError(t, err)
+	assert.InDelta(t, 7.4443, rate, 1e-9)
+
+	// the weekend uses the rate from friday
+	rate, err = n.Rate(context.Background(), "usd", time.Date(2022, 2, 6, 12, 0, 0, 0, entities.Location))
+	assert.NoError(t, err)
+	assert.InDelta(t, 6.512, rate, 1e-9)
+
+	// without a rate, EUR falls back to the central parity, others fail
+	delete(n.rates, "2022-02-07")
+	delete(n.rates, "2022-02-04")
+	rate, err = n.Rate(context.Background(), "EUR", now)
+	assert.NoError(t, err)
+	assert.Equal(t, CentralParity, rate)
+	_, err = n.Rate(context.Background(), "USD", now)
+	assert.Error(t, err)
+
+	// listed without a rate
+	_, err = n.Rate(context.Background(), "RUB", time.Date(2022, 2, 4, 12, 0, 0, 0, entities.Location))
+	assert.Error(t, err)
+}
+
+func TestNationalbanken_RateMonthsBack(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(history))
+	}))
+	defer srv.Close()
+	var n Nationalbanken
+	n.URL(srv.URL)
+	n.Clock(func() time.Time { return time.Date(2022, 6, 8, 10, 0, 0, 0, entities.Location) })
+
+	// months before the history published, EUR is estimated at the central parity
+	rate, err := n.Rate(context.Background(), "EUR", time.Date(2022, 1, 7, 12, 0, 0, 0, entities.Location))
+	require.NoError(t, err)
+	assert.Equal(t, CentralParity, rate)
+	_, err = n.Rate(context.Background(), "USD", time.Date(2022, 1, 7, 12, 0, 0, 0, entities.Location))
+	assert.Error(t, err)
+
+	// but rates fetched back then are still used
+	rate, err = n.Rate(context.Background(), "EUR", time.Date(2022, 2, 7, 12, 0, 0, 0, entities.Location))
+	require.NoError(t, err)
+	assert.InDelta(t, 7.4443, rate, 1e-9)
+}
+
+func TestNationalbanken_RateWhileFetching(t *testing.T) {
+	requested, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/CurrencyRatesHistoryXML" {
+			close(requested)
+			<-release
+		}
+		w.Write([]byte(history))
+	}))
+	defer srv.Close()
+	n := Nationalbanken{rates: make(map[string]map[string]float64)}
+	require.NoError(t, parse(strings.NewReader(history), n.rates))
+	n.URL(srv.URL)
+
+	done := make(chan error)
+	go func() {
+		_, err := n.Rate(context.Background(), "USD", time.Now())
+		done <- err
+	}()
+	<-requested
+	// rates in the cache don't wait for the fetch
+	rate, err := n.Rate(context.Background(), "EUR", time.Date(2022, 2, 7, 12, 0, 0, 0, entities.Location))
+	assert.NoError(t, err)
+	assert.InDelta(t, 7.4443, rate, 1e-9)
+
+	// nor does a caller giving up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = n.Rate(ctx, "USD", time.Now())
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	// the rates of 2022 don't reach today
+	assert.Error(t, <-done)
+}
@@ -1,35 +1,95 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/adamhassel/power"
+	"github.com/adamhassel/power/entities"
 	"github.com/adamhassel/power/entities/config"
 	"github.com/adamhassel/power/httpapi"
-	"github.com/adamhassel/power/repos/eloverblik"
+	"github.com/adamhassel/power/logging"
+	"github.com/adamhassel/power/metrics"
+	"github.com/adamhassel/power/notify"
+	"github.com/adamhassel/power/store"
+	"github.com/adamhassel/power/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var confFile string
+var confFile, logLevel, logFormat string
 var port int
 
 func init() {
 	flag.StringVar(&confFile, "c", "power.conf", "location of configuration file.")
 	flag.IntVar(&port, "p", 8080, "port to listen on")
+	flag.StringVar(&logLevel, "loglevel", "info", "log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "logformat", "text", "log format: text or json")
 }
+
 func main() {
 	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the API until it fails. Errors are returned rather than fatal,
+// so the store is closed on the way out.
+func run() error {
+	if err := logging.Setup(logLevel, logFormat); err != nil {
+		return err
+	}
 	c, err := config.LoadConfig(confFile)
 	if err != nil {
-		log.Fatalf("error reading conf: %s", err)
+		return fmt.Errorf("error reading conf: %w", err)
 	}
 	if c.MID() == "" || c.Token() == "" {
-		log.Fatal("MID or Token invalid")
+		return errors.New("MID or Token invalid")
 	}
-	if err := eloverblik.PreloadTariffs(c); err != nil {
-		log.Fatalf("error preloading tariffs: %s", err)
+	transport.Default = transport.New(c.HTTP().Options())
+	power.UseUpstream(c.Upstream())
+	ctx := context.Background()
+	if c.Store() != "" {
+		s, err := store.Open(c.Store())
+		if err != nil {
+			return fmt.Errorf("error opening store: %w", err)
+		}
+		defer s.Close()
+		power.UseStore(s)
+	}
+	if err := power.LoadTariffs(ctx, c); err != nil {
+		return fmt.Errorf("error preloading tariffs: %w", err)
+	}
+	if n := c.Notify(); n.Enabled() {
+		src := func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+			return power.Prices(ctx, from, to, c, true)
+		}
+		go notify.FromConfig(n, c.HTTP().Options()).Run(ctx, src, n.CheckInterval())
+	}
+	a, err := power.Area(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error finding price area: %w", err)
 	}
-	http.HandleFunc("/powerPrices", httpapi.GetPowerPrices)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	prometheus.MustRegister(metrics.PriceCollector{
+		Area: string(a),
+		Prices: func(ctx context.Context, from, to time.Time) ([]entities.FullPrice, error) {
+			return power.PricesInArea(ctx, from, to, a, c, true)
+		},
+		PricesUpdated:  func() time.Time { return power.PricesUpdated(a) },
+		TariffsUpdated: func() time.Time { return power.TariffsUpdated(c.MID()) },
+	})
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/powerPrices", httpapi.GetPowerPrices(power.Default, c, false))
+	http.HandleFunc("/meteringPoint", httpapi.GetMeteringPointDetails(power.Default, c))
+	http.HandleFunc("/cheapest", httpapi.GetCheapestWindow(power.Default, c, false))
+	http.HandleFunc("/cheapestSlots", httpapi.GetCheapestSlots(power.Default, c, false))
+	slog.Info("listening", "port", port, "area", a)
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
 }
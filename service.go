@@ -0,0 +1,147 @@
+package power
+
+import (
+	"context"
+	"time"
+
+	"github.com/adamhassel/power/cache"
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/eloverblik"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/repos/nationalbanken"
+	"github.com/adamhassel/power/store"
+)
+
+// TariffSource returns the provider of tariffs for the metering point configured in c
+type TariffSource func(c interfaces.Configurator) interfaces.TariffProvider
+
+// DetailsSource fetches the details of the metering point configured in c
+type DetailsSource func(ctx context.Context, c interfaces.Configurator) (eloverblik.MeteringPoint, error)
+
+// Clock tells the time
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function used as a Clock
+type ClockFunc func() time.Time
+
+// Now implements Clock
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// PriceService combines spot prices and tariffs into full prices. It caches
+// the current prices and tariffs in memory, and uses a store if it has one.
+type PriceService struct {
+	spot    interfaces.SpotPriceProvider
+	tariffs TariffSource
+	details DetailsSource
+	rates   interfaces.ExchangeRateProvider
+	clock   Clock
+	store   *store.Store
+
+	prices        *cache.Cache[energidataservice.Area, FullPrices]
+	tariffsCached *cache.Cache[string, eloverblik.FullTariffs]
+	areas         *cache.Cache[string, energidataservice.Area]
+}
+
+// NewPriceService returns a PriceService getting prices from spot, tariffs
+// from the providers returned by tariffs, and the time from clock. Any of them
+// that are nil are replaced by the defaults: energidataservice, eloverblik and
+// the system clock. Exchange rates are from Nationalbanken, unless others are
+// set with UseRates.
+func NewPriceService(spot interfaces.SpotPriceProvider, tariffs TariffSource, clock Clock) *PriceService {
+	s := &PriceService{
+		prices:        cache.New[energidataservice.Area, FullPrices](),
+		tariffsCached: cache.New[string, eloverblik.FullTariffs](),
+		areas:         cache.New[string, energidataservice.Area](),
+	}
+	s.provide(spot, tariffs, clock)
+	return s
+}
+
+// provide sets the providers of s, using the defaults for those that are nil
+func (s *PriceService) provide(spot interfaces.SpotPriceProvider, tariffs TariffSource, clock Clock) {
+	if spot == nil {
+		e := new(energidataservice.EnergiDataService)
+		e.Rates(storedRates{s})
+		spot = e
+	}
+	if tariffs == nil {
+		tariffs = func(c interfaces.Configurator) interfaces.TariffProvider {
+			return eloverblik.FromConfig(c)
+		}
+	}
+	if clock == nil {
+		clock = ClockFunc(time.Now)
+	}
+	s.spot, s.tariffs, s.clock = spot, tariffs, clock
+	s.UseRates(nil)
+	s.UseDetails(nil)
+}
+
+// UseRates makes s get exchange rates from r. They're stored with backfilled
+// prices, and the default energidataservice estimates missing DKK prices with
+// them, preferring the ones stored. A spot price provider given to
+// NewPriceService estimates DKK prices itself. A nil r gets them from
+// Nationalbanken.
+func (s *PriceService) UseRates(r interfaces.ExchangeRateProvider) {
+	if r == nil {
+		r = nationalbanken.Default
+	}
+	s.rates = r
+}
+
+// UseDetails makes s get the details of metering points with d, and detect
+// their price areas from them. A nil d gets them from eloverblik.
+func (s *PriceService) UseDetails(d DetailsSource) {
+	if d == nil {
+		d = eloverblik.FetchDetails
+	}
+	s.details = d
+}
+
+// UseStore makes s read prices and tariffs from st before fetching them, and
+// save everything fetched in it. A nil st stops using a store.
+func (s *PriceService) UseStore(st *store.Store) {
+	s.store = st
+}
+
+// Now returns the current time according to the clock of s
+func (s *PriceService) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Default is the PriceService used by the package level functions. It caches
+// in FullPricesCached and eloverblik.FullTariffsCached.
+var Default = defaultService()
+
+func defaultService() *PriceService {
+	s := &PriceService{
+		prices:        FullPricesCached,
+		tariffsCached: eloverblik.FullTariffsCached,
+		areas:         detectedAreas,
+	}
+	s.provide(nil, nil, nil)
+	return s
+}
+
+// UseStore makes the default PriceService use st. See PriceService.UseStore.
+func UseStore(st *store.Store) {
+	Default.UseStore(st)
+}
+
+// UseUpstream sets the base URLs of the upstream APIs configured in u
+func UseUpstream(u config.Upstream) {
+	if u.Energidataservice != "" {
+		energidataservice.DefaultURL = u.Energidataservice
+	}
+	if u.Eloverblik != "" {
+		eloverblik.DefaultURL = u.Eloverblik
+	}
+	if u.Nationalbanken != "" {
+		nationalbanken.DefaultURL = u.Nationalbanken
+	}
+}
@@ -0,0 +1,152 @@
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedRate float64
+
+func (r fixedRate) Rate(context.Context, string, time.Time) (float64, error) {
+	return float64(r), nil
+}
+
+// eurOnly provides hourly spot prices of 100 EUR/MWh, without DKK prices, so
+// they're estimated at the rate of eurOnly
+type eurOnly fixedRate
+
+func (r eurOnly) SpotPrices(ctx context.Context, from, to time.Time, area string) ([]entities.Elspotprice, error) {
+	var p energidataservice.Prices
+	for h := from.Truncate(time.Hour); h.Before(to); h = h.Add(time.Hour) {
+		p.Elspotprices = append(p.Elspotprices, entities.Elspotprice{
+			HourUTC:      entities.PTime(h.UTC()),
+			PriceArea:    area,
+			SpotPriceEUR: 100,
+		})
+	}
+	if err := p.FixupDKK(ctx, fixedRate(r)); err != nil {
+		return nil, err
+	}
+	return p.Elspotprices, nil
+}
+
+type tariffFunc func(ctx context.Context, from, to time.Time) (entities.FullTariffs, error)
+
+func (f tariffFunc) Tariffs(ctx context.Context, from, to time.Time) (entities.FullTariffs, error) {
+	return f(ctx, from, to)
+}
+
+// flatTariffs returns a TariffSource with a single tariff of price in every
+// hour, counting the number of fetches in calls
+func flatTariffs(t *testing.T, price float64, calls *int32) TariffSource {
+	prices := make([]string, 24)
+	for i := range prices {
+		prices[i] = fmt.Sprintf(`{"position": "%d", "price": %f}`, i+1, price)
+	}
+	raw := `{"result": [{"result": {"tariffs": [{"name": "Nettarif", "prices": [` + strings.Join(prices, ",") + `]}]}, "success": true}]}`
+	return func(interfaces.Configurator) interfaces.TariffProvider {
+		return tariffFunc(func(context.Context, time.Time, time.Time) (entities.FullTariffs, error) {
+			atomic.AddInt32(calls, 1)
+			var ft entities.FullTariffs
+			require.NoError(t, json.Unmarshal([]byte(raw), &ft))
+			return ft, nil
+		})
+	}
+}
+
+func TestPriceService(t *testing.T) {
+	now := time.Date(2025, 3, 8, 12, 0, 0, 0, entities.Location)
+	clock := ClockFunc(func() time.Time { return now })
+	var calls int32
+	s := NewPriceService(eurOnly(7.5), flatTariffs(t, 0.25, &calls), clock)
+
+	ps, err := s.PricesInArea(context.Background(), now, now.Add(2*time.Hour), energidataservice.AreaDKWest, fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	require.NoError(t, err)
+	require.Len(t, ps, 2)
+	for _, p := range ps {
+		assert.True(t, p.Estimated)
+		assert.Equal(t, 7.5, p.EstimatedRate)
+		assert.InDelta(t, 0.75, p.RawPrice, 1e-9)
+		assert.InDelta(t, (0.75+0.25)*1.25, p.TotalIncVAT, 1e-9)
+	}
+	assert.Equal(t, now, s.PricesUpdated(energidataservice.AreaDKWest))
+
+	// a second service has caches of its own
+	other := NewPriceService(eurOnly(7.5), flatTariffs(t, 0.5, &calls), clock)
+	ps, err = other.PricesInArea(context.Background(), now, now.Add(time.Hour), energidataservice.AreaDKWest, fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	require.NoError(t, err)
+	require.Len(t, ps, 1)
+	assert.InDelta(t, (0.75+0.5)*1.25, ps[0].TotalIncVAT, 1e-9)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	_, err = s.PricesInArea(context.Background(), now.Add(time.Hour), now.Add(3*time.Hour), energidataservice.AreaDKWest, fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+type spotFunc func(ctx context.Context, from, to time.Time, area string) ([]entities.Elspotprice, error)
+
+func (f spotFunc) SpotPrices(ctx context.Context, from, to time.Time, area string) ([]entities.Elspotprice, error) {
+	return f(ctx, from, to, area)
+}
+
+func TestPriceService_SpotPricesError(t *testing.T) {
+	now := time.Date(2025, 3, 8, 12, 0, 0, 0, entities.Location)
+	var calls int32
+	failing := spotFunc(func(context.Context, time.Time, time.Time, string) ([]entities.Elspotprice, error) {
+		return nil, fmt.Errorf("unavailable")
+	})
+	s := NewPriceService(failing, flatTariffs(t, 0.25, &calls), ClockFunc(func() time.Time { return now }))
+
+	// spot price outages are blamed on energidataservice, not the tariffs
+	_, err := s.PricesInArea(context.Background(), now, now.Add(time.Hour), energidataservice.AreaDKWest, fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	assert.ErrorIs(t, err, ErrEnergidataservice)
+	assert.NotErrorIs(t, err, ErrEloverblik)
+}
+
+func TestPriceService_MissingTariffsNotCached(t *testing.T) {
+	now := time.Date(2025, 3, 8, 12, 0, 0, 0, entities.Location)
+	var calls int32
+	var down atomic.Bool
+	down.Store(true)
+	flat := flatTariffs(t, 0.25, &calls)
+	tariffs := func(c interfaces.Configurator) interfaces.TariffProvider {
+		if down.Load() {
+			return tariffFunc(func(context.Context, time.Time, time.Time) (entities.FullTariffs, error) {
+				return entities.FullTariffs{}, fmt.Errorf("unavailable")
+			})
+		}
+		return flat(c)
+	}
+	s := NewPriceService(eurOnly(7.5), tariffs, ClockFunc(func() time.Time { return now }))
+	ctx := context.Background()
+	c := fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}
+
+	// while eloverblik is down, prices without tariffs are only for those who ask for them
+	ps, err := s.PricesInArea(ctx, now, now.Add(time.Hour), energidataservice.AreaDKWest, c, true)
+	require.NoError(t, err)
+	require.Len(t, ps, 1)
+	assert.Empty(t, ps[0].Taxes)
+	_, err = s.PricesInArea(ctx, now, now.Add(time.Hour), energidataservice.AreaDKWest, c, false)
+	assert.Error(t, err)
+
+	// and they aren't cached, so once the tariffs are back, they're used
+	down.Store(false)
+	require.NoError(t, s.RefreshTariffs(ctx, c))
+	ps, err = s.PricesInArea(ctx, now, now.Add(time.Hour), energidataservice.AreaDKWest, c, false)
+	require.NoError(t, err)
+	require.Len(t, ps, 1)
+	assert.NotEmpty(t, ps[0].Taxes)
+}
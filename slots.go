@@ -0,0 +1,313 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/energidataservice"
+)
+
+var ErrNoSchedule = errors.New("no schedule satisfies the constraints with the known prices")
+
+var ErrInvalidSlotOptions = errors.New("invalid slot options")
+
+// Limits on the size of a schedule. maxSlots is three days of quarter hours,
+// more than there are prices for ahead of time, and maxScheduleCells bounds the
+// memory used by schedule, which keeps a row of cells for every slot.
+const (
+	maxSlots         = 3 * 24 * 4
+	maxScheduleCells = 1 << 24
+)
+
+// SlotOptions are the constraints for picking the cheapest slots to be on in
+type SlotOptions struct {
+	// Duration is how long to be on in total. It's rounded up to whole slots,
+	// and exactly that many are picked, unless slots forced on by
+	// AlwaysOnBelow add up to more.
+	Duration time.Duration
+	// From and Deadline limit the period to pick slots in. The slot in progress
+	// at From is included. PriceService.CheapestSlots refuses a From before the
+	// current hour. A zero Deadline means as late as there are prices for.
+	From, Deadline time.Time
+	// MinBlock is the shortest time to stay on, once switched on
+	MinBlock time.Duration
+	// MaxGap is the longest time to stay off between blocks of being on. Zero
+	// means no limit. Shorter than a slot means no gaps at all.
+	MaxGap time.Duration
+	// AlwaysOnBelow switches on for any slot priced (inc. VAT) below it, even
+	// if that's more than Duration. nil disables it.
+	AlwaysOnBelow *float64
+}
+
+// Slot is a period, its price and whether to be on or off
+type Slot struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Price float64   `json:"price_inc_vat"`
+	On    bool      `json:"on"`
+}
+
+// Switch is a point in time to switch on or off
+type Switch struct {
+	At time.Time `json:"at"`
+	On bool      `json:"on"`
+}
+
+// Schedule is a list of slots to be on or off in. Average is the average price
+// per kWh of the slots that are on, and Total is the price of drawing 1 kW while on.
+type Schedule struct {
+	Slots    []Slot        `json:"slots"`
+	Switches []Switch      `json:"switches"`
+	On       time.Duration `json:"-"`
+	Average  float64       `json:"average_inc_vat"`
+	Total    float64       `json:"total_inc_vat"`
+}
+
+// validate returns an error if the durations in o make no sense
+func (o SlotOptions) validate() error {
+	switch {
+	case o.Duration <= 0:
+		return fmt.Errorf("%w: duration must be positive", ErrInvalidSlotOptions)
+	case o.MinBlock < 0:
+		return fmt.Errorf("%w: min block can't be negative", ErrInvalidSlotOptions)
+	case o.MaxGap < 0:
+		return fmt.Errorf("%w: max gap can't be negative", ErrInvalidSlotOptions)
+	}
+	return nil
+}
+
+// CheapestSlots picks the cheapest slots in fp to be on in, within the constraints in o
+func (fp FullPrices) CheapestSlots(o SlotOptions) (Schedule, error) {
+	if err := o.validate(); err != nil {
+		return Schedule{}, err
+	}
+	slots := splitSlots(fp.Contents, o.From, o.Deadline)
+	if len(slots) == 0 {
+		return Schedule{}, ErrNoSchedule
+	}
+	if len(slots) > maxSlots {
+		return Schedule{}, fmt.Errorf("%w: more than %d slots to pick from", ErrInvalidSlotOptions, maxSlots)
+	}
+	res := slots[0].To.Sub(slots[0].From)
+	units := func(d time.Duration) int {
+		return int(math.Ceil(float64(d) / float64(res)))
+	}
+	k, m := units(o.Duration), max(units(o.MinBlock), 1)
+	// there's no room for more slots than there are, so don't let the
+	// constraints blow up the size of the schedule below
+	if k > len(slots) || m > len(slots) {
+		return Schedule{}, ErrNoSchedule
+	}
+	g := -1
+	if o.MaxGap > 0 {
+		g = min(int(o.MaxGap/res), len(slots))
+	}
+	if (k+1)*(m+max(g, 1)+2)*len(slots) > maxScheduleCells {
+		return Schedule{}, fmt.Errorf("%w: too many slots for the duration, min block and max gap", ErrInvalidSlotOptions)
+	}
+	forced := make([]bool, len(slots))
+	for i, s := range slots {
+		forced[i] = o.AlwaysOnBelow != nil && s.Price < *o.AlwaysOnBelow
+		if forced[i] {
+			k--
+		}
+	}
+	on, ok := schedule(slots, forced, max(k, 0), m, g)
+	if !ok {
+		return Schedule{}, ErrNoSchedule
+	}
+	var rv Schedule
+	for i := range slots {
+		slots[i].On = on[i]
+		if on[i] {
+			d := slots[i].To.Sub(slots[i].From)
+			rv.On += d
+			rv.Total += slots[i].Price * d.Hours()
+		}
+		if i == 0 || on[i] != on[i-1] {
+			rv.Switches = append(rv.Switches, Switch{At: slots[i].From, On: on[i]})
+		}
+	}
+	rv.Slots = slots
+	if rv.On > 0 {
+		rv.Average = rv.Total / rv.On.Hours()
+	}
+	return rv, nil
+}
+
+// splitSlots returns the prices in ps ending after from and ending before to
+// (if set), as slots all as long as the shortest price period. Slots stop at
+// the first hole in the prices.
+func splitSlots(ps []entities.FullPrice, from, to time.Time) []Slot {
+	var res time.Duration
+	for _, p := range ps {
+		if d := p.ValidTo.Sub(p.ValidFrom); res == 0 || d < res {
+			res = d
+		}
+	}
+	var rv []Slot
+	for _, p := range ps {
+		if !p.ValidTo.After(from) || (!to.IsZero() && p.ValidTo.After(to)) {
+			continue
+		}
+		if len(rv) > 0 && !rv[len(rv)-1].To.Equal(p.ValidFrom) {
+			break
+		}
+		for t := p.ValidFrom; t.Before(p.ValidTo); t = t.Add(res) {
+			rv = append(rv, Slot{From: t, To: t.Add(res), Price: p.TotalIncVAT})
+		}
+	}
+	return rv
+}
+
+// schedule finds the cheapest way to be on in exactly k slots besides the
+// forced ones, which are always on, where every block of being on is at least
+// m slots long, and blocks are at most g slots apart (unless g is negative).
+// It returns which slots to be on in, and false if there's no way to satisfy
+// the constraints.
+//
+// It's a dynamic program over the slots, where the state after each slot is
+// the number of unforced slots on so far, and where in a block or gap we are.
+func schedule(slots []Slot, forced []bool, k, m, g int) ([]bool, bool) {
+	gaps := g
+	if gaps < 0 {
+		gaps = 1
+	}
+	// states: 0 is before the first block, 1..m is on for that many slots (capped
+	// at m), m+1..m+gaps is off for that many slots after a block, and done is
+	// off after the last block, which isn't limited by g.
+	const notStarted = 0
+	done := m + gaps + 1
+	nStates := done + 1
+	onState := func(s int) bool { return s >= 1 && s <= m }
+	next := func(s int, on bool) int {
+		switch {
+		case on && (s == notStarted || s > m) && s != done:
+			return 1
+		case on && onState(s):
+			if s < m {
+				return s + 1
+			}
+			return m
+		case on:
+			return -1
+		case s == notStarted || s == done:
+			return s
+		case onState(s):
+			if s < m || g == 0 {
+				return -1
+			}
+			return m + 1
+		case g < 0:
+			return s
+		case s-m < g:
+			return s + 1
+		}
+		return -1
+	}
+
+	inf := math.Inf(1)
+	width := (k + 1) * nStates
+	cost := make([]float64, width)
+	for i := range cost {
+		cost[i] = inf
+	}
+	cost[notStarted] = 0
+	parent := make([][]int32, len(slots))
+	var targets [2]int
+	for i, slot := range slots {
+		nc := make([]float64, width)
+		for j := range nc {
+			nc[j] = inf
+		}
+		parent[i] = make([]int32, width)
+		price := slot.Price * slot.To.Sub(slot.From).Hours()
+		for c := 0; c <= k; c++ {
+			for s := 0; s < nStates; s++ {
+				cur := cost[c*nStates+s]
+				if math.IsInf(cur, 1) {
+					continue
+				}
+				for _, on := range []bool{false, true} {
+					if forced[i] && !on {
+						continue
+					}
+					nc2, add := c, 0.0
+					if on {
+						add = price
+						if !forced[i] {
+							if c == k {
+								continue
+							}
+							nc2++
+						}
+					}
+					targets[0], targets[1] = next(s, on), -1
+					// ending a block may also be the end of the last one
+					if !on && onState(s) && s == m {
+						targets[1] = done
+					}
+					for _, ns := range targets {
+						if ns < 0 {
+							continue
+						}
+						idx := nc2*nStates + ns
+						if cur+add < nc[idx] {
+							nc[idx] = cur + add
+							parent[i][idx] = int32(c*nStates + s)
+						}
+					}
+				}
+			}
+		}
+		cost = nc
+	}
+
+	best, bestIdx := inf, -1
+	for s := 0; s < nStates; s++ {
+		if s == notStarted && k > 0 {
+			continue
+		}
+		if onState(s) && s < m {
+			continue
+		}
+		if idx := k*nStates + s; cost[idx] < best {
+			best, bestIdx = cost[idx], idx
+		}
+	}
+	if bestIdx < 0 {
+		return nil, false
+	}
+	rv := make([]bool, len(slots))
+	for i := len(slots) - 1; i >= 0; i-- {
+		rv[i] = onState(bestIdx % nStates)
+		bestIdx = int(parent[i][bestIdx])
+	}
+	return rv, true
+}
+
+// CheapestSlots fetches prices in the area a, and picks the cheapest slots to be
+// on in, within the constraints in o. See FullPrices.CheapestSlots.
+func (s *PriceService) CheapestSlots(ctx context.Context, o SlotOptions, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) (Schedule, error) {
+	if err := o.validate(); err != nil {
+		return Schedule{}, err
+	}
+	if o.From.Before(s.clock.Now().Truncate(time.Hour)) {
+		return Schedule{}, fmt.Errorf("%w: from can't be in the past", ErrInvalidSlotOptions)
+	}
+	fp, err := s.pricesAround(ctx, o.From, o.Deadline, a, c, ignoreMissingTariffs)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return fp.CheapestSlots(o)
+}
+
+// CheapestSlots calls CheapestSlots on the default PriceService
+func CheapestSlots(ctx context.Context, o SlotOptions, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) (Schedule, error) {
+	return Default.CheapestSlots(ctx, o, a, c, ignoreMissingTariffs)
+}
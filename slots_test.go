@@ -0,0 +1,105 @@
+package power
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// onHours returns the hours after start the slots in s are on in
+func onHours(s Schedule, start time.Time) []int {
+	var rv []int
+	for _, slot := range s.Slots {
+		if slot.On {
+			rv = append(rv, int(slot.From.Sub(start)/time.Hour))
+		}
+	}
+	return rv
+}
+
+func TestFullPrices_CheapestSlots(t *testing.T) {
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	fp := testPrices(start, time.Hour, 5, 1, 6, 6, 2, 6, 6, 6, 0.5, 6)
+	ceiling := 2.5
+
+	tests := []struct {
+		name string
+		o    SlotOptions
+		want []int
+	}{
+		{name: "three cheapest", o: SlotOptions{Duration: 3 * time.Hour}, want: []int{1, 4, 8}},
+		{name: "before deadline", o: SlotOptions{Duration: 2 * time.Hour, Deadline: start.Add(6 * time.Hour)}, want: []int{1, 4}},
+		{name: "from", o: SlotOptions{Duration: 2 * time.Hour, From: start.Add(90 * time.Minute)}, want: []int{1, 8}},
+		{name: "min block", o: SlotOptions{Duration: 4 * time.Hour, MinBlock: 2 * time.Hour}, want: []int{0, 1, 8, 9}},
+		{name: "max gap", o: SlotOptions{Duration: 3 * time.Hour, MaxGap: 2 * time.Hour}, want: []int{0, 1, 4}},
+		{name: "always on below ceiling", o: SlotOptions{Duration: time.Hour, AlwaysOnBelow: &ceiling}, want: []int{1, 4, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := fp.CheapestSlots(tt.o)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, onHours(s, start))
+		})
+	}
+}
+
+func TestFullPrices_CheapestSlotsSwitches(t *testing.T) {
+	start := time.Date(2025, 10, 2, 0, 0, 0, 0, entities.Location)
+	// an hourly price followed by quarter hours is split into quarters
+	fp := testPrices(start, time.Hour, 1)
+	fp.Contents = append(fp.Contents, testPrices(start.Add(time.Hour), 15*time.Minute, 9, 9, 0.5, 0.5).Contents...)
+
+	s, err := fp.CheapestSlots(SlotOptions{Duration: 30 * time.Minute})
+	require.NoError(t, err)
+	require.Len(t, s.Slots, 8)
+	assert.Equal(t, []Switch{{At: start, On: false}, {At: start.Add(90 * time.Minute), On: true}}, s.Switches)
+	assert.Equal(t, 30*time.Minute, s.On)
+	assert.InDelta(t, 0.5, s.Average, 1e-9)
+	assert.InDelta(t, 0.25, s.Total, 1e-9)
+
+	_, err = fp.CheapestSlots(SlotOptions{Duration: 3 * time.Hour})
+	assert.ErrorIs(t, err, ErrNoSchedule)
+}
+
+func TestFullPrices_CheapestSlotsLimits(t *testing.T) {
+	start := time.Date(2025, 10, 2, 0, 0, 0, 0, entities.Location)
+	fp := testPrices(start, time.Hour, -1, -2, 3, -1, -3, 4, -1.5, -1, 5, -1)
+
+	for _, o := range []SlotOptions{{Duration: -time.Hour}, {Duration: 0}, {Duration: time.Hour, MinBlock: -time.Hour}, {Duration: time.Hour, MaxGap: -time.Hour}} {
+		_, err := fp.CheapestSlots(o)
+		assert.ErrorIs(t, err, ErrInvalidSlotOptions, o)
+	}
+	_, err := fp.CheapestSlots(SlotOptions{Duration: 1000 * time.Hour, MinBlock: 1000 * time.Hour, MaxGap: 1000 * time.Hour})
+	assert.ErrorIs(t, err, ErrNoSchedule)
+
+	// negative prices don't make it pick more than asked for
+	s, err := fp.CheapestSlots(SlotOptions{Duration: 2 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, s.On)
+	assert.Equal(t, []int{1, 4}, onHours(s, start))
+
+	// a max gap shorter than a slot allows no gaps
+	s, err = fp.CheapestSlots(SlotOptions{Duration: 2 * time.Hour, MaxGap: 30 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 4}, onHours(s, start))
+
+	// the size of the schedule is limited
+	week := testPrices(start, 15*time.Minute, make([]float64, 7*24*4)...)
+	_, err = week.CheapestSlots(SlotOptions{Duration: time.Hour})
+	assert.ErrorIs(t, err, ErrInvalidSlotOptions)
+	days := testPrices(start, 15*time.Minute, make([]float64, maxSlots)...)
+	_, err = days.CheapestSlots(SlotOptions{Duration: 70 * time.Hour, MinBlock: 70 * time.Hour, MaxGap: 70 * time.Hour})
+	assert.ErrorIs(t, err, ErrInvalidSlotOptions)
+}
+
+func TestPriceService_CheapestSlotsPast(t *testing.T) {
+	now := time.Date(2025, 10, 6, 10, 30, 0, 0, entities.Location)
+	s := NewPriceService(nil, nil, ClockFunc(func() time.Time { return now }))
+	_, err := s.CheapestSlots(context.Background(), SlotOptions{Duration: time.Hour, From: now.AddDate(0, 0, -7)}, energidataservice.AreaDKWest, nil, true)
+	assert.ErrorIs(t, err, ErrInvalidSlotOptions)
+}
@@ -0,0 +1,178 @@
+package power
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/entities/config"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/eloverblik"
+	"github.com/adamhassel/power/repos/energidataservice"
+)
+
+// storedPrices returns the prices from `from` to `to` in the area a from the
+// store, and true if they cover the whole period. Prices with estimated DKK
+// prices, or without tariffs, don't count, since they may be improved on by
+// fetching them again.
+func (s *PriceService) storedPrices(a energidataservice.Area, from, to time.Time) (FullPrices, bool) {
+	if s.store == nil {
+		return FullPrices{}, false
+	}
+	ps, err := s.store.Prices(string(a), from.Truncate(time.Hour), to)
+	if err != nil {
+		slog.Error("error reading prices from store", "err", err)
+		return FullPrices{}, false
+	}
+	if len(ps) == 0 || ps[0].ValidFrom.After(from) || ps[len(ps)-1].ValidTo.Before(to) {
+		return FullPrices{}, false
+	}
+	for i, p := range ps {
+		if p.Estimated || len(p.Taxes) == 0 || (i > 0 && !ps[i-1].ValidTo.Equal(p.ValidFrom)) {
+			return FullPrices{}, false
+		}
+	}
+	return FullPrices{Contents: ps, From: ps[0].ValidFrom, To: ps[len(ps)-1].ValidTo}, true
+}
+
+// save saves spot prices p and the full prices fp in the area a in the store,
+// if there is one. Full prices without tariffs aren't saved.
+func (s *PriceService) save(a energidataservice.Area, p energidataservice.Prices, fp FullPrices) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SaveSpotPrices(string(a), p.Elspotprices); err != nil {
+		slog.Error("error saving spot prices", "err", err)
+	}
+	withTariffs := make([]entities.FullPrice, 0, len(fp.Contents))
+	for _, f := range fp.Contents {
+		if len(f.Taxes) > 0 {
+			withTariffs = append(withTariffs, f)
+		}
+	}
+	if err := s.store.SavePrices(string(a), withTariffs); err != nil {
+		slog.Error("error saving prices", "err", err)
+	}
+}
+
+// LoadTariffs loads the tariffs for the metering point in c. A snapshot
+// from the store is used if it's less than a day old, otherwise they're
+// fetched, stamped with the service's time, and saved in the store.
+// Concurrent loads for the same metering point only fetch once.
+func (s *PriceService) LoadTariffs(ctx context.Context, c interfaces.Configurator) error {
+	_, err := s.tariffsCached.Load(ctx, c.MID(), func(ctx context.Context) (eloverblik.FullTariffs, error) {
+		now := s.clock.Now()
+		if s.store != nil {
+			if ft, err := s.store.Tariffs(c.MID(), now); err == nil && now.Sub(ft.UpdatedAt()) < 24*time.Hour {
+				return ft, nil
+			}
+		}
+		ft, err := s.tariffs(c).Tariffs(ctx, now, now.Add(24*time.Hour))
+		if err != nil {
+			return ft, err
+		}
+		ft.SetUpdatedAt(now)
+		if s.store != nil {
+			if err := s.store.SaveTariffs(c.MID(), ft); err != nil {
+				slog.ErrorContext(ctx, "error saving tariffs", "err", err)
+			}
+		}
+		return ft, nil
+	})
+	return err
+}
+
+// LoadTariffs calls LoadTariffs on the default PriceService
+func LoadTariffs(ctx context.Context, c interfaces.Configurator) error {
+	return Default.LoadTariffs(ctx, c)
+}
+
+// RefreshTariffs loads the tariffs for the metering point in c, if the ones
+// in memory are more than a day old
+func (s *PriceService) RefreshTariffs(ctx context.Context, c interfaces.Configurator) error {
+	if s.clock.Now().Sub(s.TariffsUpdated(c.MID())) > 24*time.Hour {
+		return s.LoadTariffs(ctx, c)
+	}
+	return nil
+}
+
+// RefreshTariffs calls RefreshTariffs on the default PriceService
+func RefreshTariffs(ctx context.Context, c interfaces.Configurator) error {
+	return Default.RefreshTariffs(ctx, c)
+}
+
+// CachedTariffs returns the tariffs in memory for the metering point mid,
+// which are empty if none have been loaded
+func (s *PriceService) CachedTariffs(mid string) eloverblik.FullTariffs {
+	ft, _ := s.tariffsCached.Get(mid)
+	return ft
+}
+
+// TariffsUpdated returns when the tariffs in memory for the metering point
+// mid were fetched, or the zero time if there are none
+func (s *PriceService) TariffsUpdated(mid string) time.Time {
+	return s.CachedTariffs(mid).UpdatedAt()
+}
+
+// TariffsUpdated calls TariffsUpdated on the default PriceService
+func TariffsUpdated(mid string) time.Time {
+	return Default.TariffsUpdated(mid)
+}
+
+// tariffsAt returns the tariffs for the metering point in c as they were known
+// at t. That's the latest snapshot in the store from before t, and stored is
+// true. If there is none, the current tariffs are returned. eloverblik only
+// returns the charges currently attached to the metering point, so tariffs that
+// ran out before the first snapshot was saved aren't known. If c is nil, the
+// global configuration is used.
+func (s *PriceService) tariffsAt(ctx context.Context, c interfaces.Configurator, t time.Time) (ft eloverblik.FullTariffs, stored bool, err error) {
+	if c == nil {
+		c = config.GetConf()
+	}
+	if s.store != nil {
+		if ft, err := s.store.Tariffs(c.MID(), t); err == nil {
+			return ft, true, nil
+		}
+	}
+	if s.TariffsUpdated(c.MID()).IsZero() {
+		if err := s.LoadTariffs(ctx, c); err != nil {
+			return eloverblik.FullTariffs{}, false, err
+		}
+	}
+	return s.CachedTariffs(c.MID()), false, nil
+}
+
+// storedRates are the exchange rates of a PriceService: the ones in its store,
+// or else the ones from its provider
+type storedRates struct {
+	s *PriceService
+}
+
+// Rate implements interfaces.ExchangeRateProvider
+func (r storedRates) Rate(ctx context.Context, currency string, t time.Time) (float64, error) {
+	if r.s.store != nil {
+		if rate, err := r.s.store.Rate(currency, t); err == nil {
+			return rate, nil
+		}
+	}
+	return r.s.rates.Rate(ctx, currency, t)
+}
+
+// saveRates saves the EUR exchange rate of each day from `from` to `to` in the
+// store, unless it's there already
+func (s *PriceService) saveRates(ctx context.Context, from, to time.Time) error {
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		if _, err := s.store.Rate("EUR", d); err == nil {
+			continue
+		}
+		rate, err := s.rates.Rate(ctx, "EUR", d)
+		if err != nil {
+			return err
+		}
+		if err := s.store.SaveRate("EUR", d, rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package power
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/adamhassel/power/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredPrices(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "power.db"))
+	require.NoError(t, err)
+	defer st.Close()
+	s := NewPriceService(nil, nil, nil)
+	s.UseStore(st)
+
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	fp := testPrices(start, time.Hour, 1, 2, 3, 4, 5, 6)
+	for i := range fp.Contents {
+		fp.Contents[i].Taxes = entities.Taxes{{Name: "Elafgift", Amount: 0.7}}
+	}
+	fp.Contents[4].Estimated = true
+	// the last one has no tariffs, and isn't saved
+	fp.Contents[5].Taxes = nil
+	s.save(energidataservice.AreaDKEast, energidataservice.Prices{}, fp)
+
+	tests := []struct {
+		name     string
+		area     energidataservice.Area
+		from, to time.Time
+		ok       bool
+	}{
+		{name: "covered", from: start, to: start.Add(4 * time.Hour), ok: true},
+		{name: "within an hour", from: start.Add(30 * time.Minute), to: start.Add(2 * time.Hour), ok: true},
+		{name: "estimated", from: start, to: start.Add(5 * time.Hour)},
+		{name: "not saved", from: start.Add(5 * time.Hour), to: start.Add(6 * time.Hour)},
+		{name: "before", from: start.Add(-time.Hour), to: start.Add(time.Hour)},
+		{name: "other area", area: energidataservice.AreaDKWest, from: start, to: start.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := energidataservice.AreaDKEast
+			if tt.area != "" {
+				a = tt.area
+			}
+			got, ok := s.storedPrices(a, tt.from, tt.to)
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, fp.Range(tt.from, tt.to).Contents, got.Range(tt.from, tt.to).Contents)
+			}
+		})
+	}
+}
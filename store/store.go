@@ -0,0 +1,208 @@
+// Package store persists spot prices, tariffs and full prices in an embedded
+// key-value store, so they survive restarts and can be used for history.
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when there's nothing stored for a lookup
+var ErrNotFound = errors.New("not found in store")
+
+// Bucket name prefixes. Spot and full prices are kept in a bucket per area,
+// tariffs in a bucket per metering point, and exchange rates in a bucket per
+// currency.
+const (
+	spotBucket    = "spot/"
+	pricesBucket  = "prices/"
+	tariffsBucket = "tariffs/"
+	ratesBucket   = "rates/"
+)
+
+// Store is a persistent store of prices, tariffs and exchange rates
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the store in the file at path, creating it if it doesn't exist.
+// Only one process can have a store open at a time.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// key is t as a key, sorting in time order
+func key(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.Unix()))
+	return k
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(k)), 0)
+}
+
+// put saves the JSON of each value in vs under the key returned for it by k,
+// in the bucket named name. Existing values are overwritten.
+func (s *Store) put(name string, n int, k func(i int) time.Time, v func(i int) interface{}) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			data, err := json.Marshal(v(i))
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key(k(i)), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scan calls f with the values keyed from `from` until `to` in the bucket named name
+func (s *Store) scan(name string, from, to time.Time, f func(k, v []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		end := key(to)
+		for k, v := c.Seek(key(from)); k != nil && string(k) < string(end); k, v = c.Next() {
+			if err := f(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveSpotPrices saves spot prices in the area a, keyed by their start
+func (s *Store) SaveSpotPrices(a string, ps []entities.Elspotprice) error {
+	return s.put(spotBucket+a, len(ps),
+		func(i int) time.Time { return time.Time(ps[i].HourUTC) },
+		func(i int) interface{} { return ps[i] })
+}
+
+// SpotPrices returns the spot prices in the area a starting from `from` and before `to`
+func (s *Store) SpotPrices(a string, from, to time.Time) ([]entities.Elspotprice, error) {
+	var rv []entities.Elspotprice
+	err := s.scan(spotBucket+a, from, to, func(_, v []byte) error {
+		var p entities.Elspotprice
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		rv = append(rv, p)
+		return nil
+	})
+	return rv, err
+}
+
+// SavePrices saves full prices in the area a, keyed by their start
+func (s *Store) SavePrices(a string, ps []entities.FullPrice) error {
+	return s.put(pricesBucket+a, len(ps),
+		func(i int) time.Time { return ps[i].ValidFrom },
+		func(i int) interface{} { return ps[i] })
+}
+
+// Prices returns the full prices in the area a starting from `from` and before `to`
+func (s *Store) Prices(a string, from, to time.Time) ([]entities.FullPrice, error) {
+	var rv []entities.FullPrice
+	err := s.scan(pricesBucket+a, from, to, func(_, v []byte) error {
+		var p entities.FullPrice
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		p.ValidFrom = p.ValidFrom.In(entities.Location)
+		p.ValidTo = p.ValidTo.In(entities.Location)
+		rv = append(rv, p)
+		return nil
+	})
+	return rv, err
+}
+
+// SaveTariffs saves a snapshot of the tariffs for the metering point mid,
+// keyed by when they were fetched
+func (s *Store) SaveTariffs(mid string, ft entities.FullTariffs) error {
+	return s.put(tariffsBucket+mid, 1,
+		func(int) time.Time { return ft.UpdatedAt() },
+		func(int) interface{} { return ft })
+}
+
+// Tariffs returns the latest snapshot of the tariffs for the metering point
+// mid fetched no later than t. Returns ErrNotFound if there is none.
+func (s *Store) Tariffs(mid string, t time.Time) (entities.FullTariffs, error) {
+	var rv entities.FullTariffs
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tariffsBucket + mid))
+		if b == nil {
+			return ErrNotFound
+		}
+		c := b.Cursor()
+		k, v := c.Seek(key(t.Add(time.Second)))
+		// Seek finds the first snapshot after t, so step back to the one before it
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &rv); err != nil {
+			return err
+		}
+		rv.SetUpdatedAt(keyTime(k))
+		return nil
+	})
+	return rv, err
+}
+
+// day is the start of t's day in Danish time, which exchange rates are keyed by
+func day(t time.Time) time.Time {
+	t = t.In(entities.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, entities.Location)
+}
+
+// SaveRate saves the rate in DKK per unit of currency on t's day
+func (s *Store) SaveRate(currency string, t time.Time, rate float64) error {
+	return s.put(ratesBucket+currency, 1,
+		func(int) time.Time { return day(t) },
+		func(int) interface{} { return rate })
+}
+
+// Rate returns the rate in DKK per unit of currency saved for t's day.
+// Returns ErrNotFound if there is none.
+func (s *Store) Rate(currency string, t time.Time) (float64, error) {
+	var rv float64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ratesBucket + currency))
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get(key(day(t)))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &rv)
+	})
+	return rv, err
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "power.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStore_Prices(t *testing.T) {
+	s := testStore(t)
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	var ps []entities.FullPrice
+	for i := 0; i < 4; i++ {
+		from := start.Add(time.Duration(i) * time.Hour)
+		ps = append(ps, entities.FullPrice{ValidFrom: from, ValidTo: from.Add(time.Hour), TotalIncVAT: float64(i), Taxes: entities.Taxes{{Name: "Elafgift", Amount: 0.7}}})
+	}
+	require.NoError(t, s.SavePrices("DK2", ps))
+	// saving again overwrites
+	ps[1].TotalIncVAT = 10
+	require.NoError(t, s.SavePrices("DK2", ps[1:2]))
+
+	got, err := s.Prices("DK2", start.Add(time.Hour), start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, ps[1:3], got)
+
+	got, err = s.Prices("DK1", start, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestStore_SpotPrices(t *testing.T) {
+	s := testStore(t)
+	dkk := 1234.5
+	hour := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	ps := []entities.Elspotprice{
+		{HourUTC: entities.PTime(hour), HourDK: entities.PTime(hour.Add(time.Hour)), PriceArea: "DK2", SpotPriceDKK: &dkk, SpotPriceEUR: 165.9},
+		{HourUTC: entities.PTime(hour.Add(time.Hour)), PriceArea: "DK2", SpotPriceEUR: 170, Resolution: 15 * time.Minute},
+	}
+	require.NoError(t, s.SaveSpotPrices("DK2", ps))
+	got, err := s.SpotPrices("DK2", hour, hour.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.True(t, time.Time(got[0].HourUTC).Equal(hour))
+	assert.Equal(t, dkk, *got[0].SpotPriceDKK)
+	assert.Nil(t, got[1].SpotPriceDKK)
+	assert.Equal(t, 15*time.Minute, got[1].Resolution)
+}
+
+func TestStore_Tariffs(t *testing.T) {
+	s := testStore(t)
+	_, err := s.Tariffs("571313100000000000", time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	var ft entities.FullTariffs
+	require.NoError(t, json.Unmarshal([]byte(`{"result":[{"result":{"meteringPointId":"571313100000000000","tariffs":[{"name":"Nettarif"}]}}]}`), &ft))
+	first := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+	ft.SetUpdatedAt(first)
+	require.NoError(t, s.SaveTariffs("571313100000000000", ft))
+	ft.Result[0].Result.Tariffs[0].Name = "Nettarif C"
+	ft.SetUpdatedAt(first.AddDate(0, 0, 1))
+	require.NoError(t, s.SaveTariffs("571313100000000000", ft))
+
+	tests := []struct {
+		at   time.Time
+		name string
+	}{
+		{at: first, name: "Nettarif"},
+		{at: first.Add(time.Hour), name: "Nettarif"},
+		{at: first.AddDate(0, 0, 2), name: "Nettarif C"},
+	}
+	for _, tt := range tests {
+		got, err := s.Tariffs("571313100000000000", tt.at)
+		require.NoError(t, err)
+		assert.Equal(t, tt.name, got.Result[0].Result.Tariffs[0].Name)
+	}
+	got, _ := s.Tariffs("571313100000000000", first.AddDate(0, 0, 2))
+	assert.True(t, got.UpdatedAt().Equal(first.AddDate(0, 0, 1)))
+
+	_, err = s.Tariffs("571313100000000000", first.Add(-time.Hour))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_Rate(t *testing.T) {
+	s := testStore(t)
+	d := time.Date(2025, 3, 7, 0, 0, 0, 0, entities.Location)
+	_, err := s.Rate("EUR", d)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.SaveRate("EUR", d.Add(12*time.Hour), 7.4602))
+	// any time of the day, in Danish time
+	for _, at := range []time.Time{d, d.Add(23 * time.Hour), d.Add(30 * time.Minute).UTC()} {
+		rate, err := s.Rate("EUR", at)
+		require.NoError(t, err)
+		assert.Equal(t, 7.4602, rate)
+	}
+	_, err = s.Rate("EUR", d.AddDate(0, 0, 1))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.Rate("USD", d)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,299 @@
+// Package transport is the HTTP client used for requests to the upstream APIs.
+// It times out each request, retries with backoff when an upstream is
+// overloaded or failing, and stops calling an upstream that keeps failing for
+// a while, with a circuit breaker per host.
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for zero Options
+const (
+	defaultTimeout         = 30 * time.Second
+	defaultRetries         = 3
+	defaultBackoff         = time.Second
+	defaultMaxBackoff      = 30 * time.Second
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = time.Minute
+)
+
+// ErrCircuitOpen is returned for requests to a host that has failed too many
+// times in a row, until its cooldown is over
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Options configures a Transport. Zero values are replaced by the defaults.
+type Options struct {
+	// Timeout is the timeout of each attempt at a request. Default 30s.
+	Timeout time.Duration
+	// Retries is how many times to retry a request after a network error, 429
+	// or 5xx response. Default 3, negative for none.
+	Retries int
+	// Backoff is the wait before the first retry, doubling for each retry
+	// after that. Default 1s.
+	Backoff time.Duration
+	// MaxBackoff is the longest to wait before a retry. A Retry-After longer
+	// than this isn't retried. Default 30s.
+	MaxBackoff time.Duration
+	// BreakerFailures is how many failed requests in a row to a host open the
+	// circuit breaker. Default 5.
+	BreakerFailures int
+	// BreakerCooldown is how long the breaker stays open, before letting a
+	// single request through to see if the host has recovered. Default 1m.
+	BreakerCooldown time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.Retries == 0 {
+		o.Retries = defaultRetries
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = defaultBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	if o.BreakerFailures <= 0 {
+		o.BreakerFailures = defaultBreakerFailures
+	}
+	if o.BreakerCooldown <= 0 {
+		o.BreakerCooldown = defaultBreakerCooldown
+	}
+	return o
+}
+
+// Default is the client used for upstream requests, unless one is given
+var Default = New(Options{})
+
+// Client returns c, or Default if c is nil
+func Client(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return Default
+}
+
+// New returns a client using a Transport with the options o
+func New(o Options) *http.Client {
+	return &http.Client{Transport: NewTransport(o)}
+}
+
+// Transport is an http.RoundTripper adding timeouts, retries and circuit
+// breakers to the requests done by Base. Requests with a body are only
+// retried if it can be replayed, i.e. the request has GetBody.
+type Transport struct {
+	// Base does the actual requests. Default is http.DefaultTransport.
+	Base http.RoundTripper
+
+	opts     Options
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewTransport returns a Transport with the options o
+func NewTransport(o Options) *Transport {
+	return &Transport{opts: o.withDefaults(), breakers: make(map[string]*breaker)}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if !t.allow(host) {
+		return nil, fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+	}
+	resp, err := t.retry(req)
+	// cancellations by the caller say nothing about the host
+	t.done(host, req.Context().Err() == nil, err != nil || failed(resp))
+	return resp, err
+}
+
+// retry does req, retrying as configured
+func (t *Transport) retry(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		resp, err := t.attempt(r)
+		if attempt >= t.opts.Retries || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+		wait, ok := t.backoff(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			slog.WarnContext(req.Context(), "retrying upstream request", "url", req.URL.Redacted(), "status", resp.StatusCode, "wait", wait)
+		} else {
+			slog.WarnContext(req.Context(), "retrying upstream request", "url", req.URL.Redacted(), "err", err, "wait", wait)
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt does a single attempt at req, with the timeout
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.opts.Timeout)
+	slog.DebugContext(ctx, "upstream request", "method", req.Method, "url", req.URL.Redacted(), "headers", loggable(req.Header))
+	start := time.Now()
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		slog.DebugContext(ctx, "upstream request failed", "url", req.URL.Redacted(), "err", err, "duration", time.Since(start))
+		return nil, err
+	}
+	slog.DebugContext(ctx, "upstream response", "url", req.URL.Redacted(), "status", resp.StatusCode, "duration", time.Since(start))
+	// the timeout covers reading the body, too
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// loggable returns a copy of h without the headers carrying credentials, so
+// they never reach a log, whatever handler it has
+func loggable(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+		h.Del(k)
+	}
+	return h
+}
+
+// backoff returns how long to wait before retry number attempt+1, and false if
+// the response asks for a longer wait than MaxBackoff
+func (t *Transport) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d, d <= t.opts.MaxBackoff
+		}
+	}
+	d := t.opts.Backoff << attempt
+	if d > t.opts.MaxBackoff || d <= 0 {
+		d = t.opts.MaxBackoff
+	}
+	return d, true
+}
+
+// retryAfter parses the Retry-After header value v, in seconds or as an HTTP date
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// retryable returns true if a request failing with resp or err may succeed if retried
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return failed(resp)
+}
+
+// failed returns true if resp means the host is overloaded or failing
+func failed(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// cancelBody cancels the context of a request when its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// breaker is the circuit breaker state of a host
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// probing is true while a request is let through to a host with an open breaker
+	probing bool
+}
+
+// allow returns true if a request to host may be done
+func (t *Transport) allow(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.breakers[host]
+	if b == nil || b.failures < t.opts.BreakerFailures {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records the outcome of a request to host, if it counts
+func (t *Transport) done(host string, counts, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.breakers[host]
+	if b == nil {
+		b = new(breaker)
+		t.breakers[host] = b
+	}
+	b.probing = false
+	if !counts {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= t.opts.BreakerFailures {
+		b.openUntil = time.Now().Add(t.opts.BreakerCooldown)
+		slog.Warn("circuit breaker open", "host", host, "failures", b.failures, "cooldown", t.opts.BreakerCooldown)
+	}
+}
@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// server replies with the statuses in order, and then 200 with the request body
+func server(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestTransport_Retry(t *testing.T) {
+	srv, calls := server(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	c := New(Options{Backoff: time.Millisecond})
+
+	resp, err := c.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// the body is sent again on every attempt
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestTransport_GiveUp(t *testing.T) {
+	srv, calls := server(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	c := New(Options{Backoff: time.Millisecond, Retries: 1})
+
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	// client errors aren't retried
+	srv, calls = server(t, http.StatusUnauthorized)
+	resp, err = c.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestTransport_RetryAfterTooLong(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := New(Options{Backoff: time.Millisecond, MaxBackoff: time.Second})
+
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTransport_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	c := New(Options{Timeout: 20 * time.Millisecond, Retries: -1})
+
+	start := time.Now()
+	_, err := c.Get(srv.URL)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestTransport_Breaker(t *testing.T) {
+	srv, calls := server(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	c := New(Options{Retries: -1, BreakerFailures: 2, BreakerCooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	_, err := c.Get(srv.URL)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	// after the cooldown, a request is let through, and closes the breaker when it succeeds
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2025, 10, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.v, now)
+		assert.Equal(t, tt.ok, ok, tt.v)
+		assert.Equal(t, tt.want, got, tt.v)
+	}
+}
+
+func TestTransport_LogsNoCredentials(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/json")
+	resp, err := New(Options{}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	// removed from the log, not the request
+	assert.Equal(t, "Bearer secret", auth)
+	assert.NotContains(t, buf.String(), "secret")
+	assert.Contains(t, buf.String(), "application/json")
+}
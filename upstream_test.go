@@ -0,0 +1,104 @@
+package power
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/internal/fakeupstream"
+	"github.com/adamhassel/power/repos/energidataservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService returns a PriceService fetching everything from the stand-ins
+// in up, at the time now
+func fakeService(up *fakeupstream.Upstream, now time.Time) *PriceService {
+	tariffs := func(c interfaces.Configurator) interfaces.TariffProvider { return up.Tariffs(c) }
+	s := NewPriceService(up.SpotPrices(), tariffs, ClockFunc(func() time.Time { return now }))
+	s.UseRates(up.Rates())
+	s.UseDetails(up.Details)
+	return s
+}
+
+func TestPrices_Weekend(t *testing.T) {
+	up := fakeupstream.New(t)
+	s := fakeService(up, time.Date(2025, 10, 6, 12, 0, 0, 0, entities.Location))
+	// Saturday only has EUR prices
+	from := time.Date(2025, 3, 8, 0, 0, 0, 0, entities.Location)
+	ps, err := s.Prices(context.Background(), from, from.Add(24*time.Hour), fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	require.NoError(t, err)
+	require.Len(t, ps, 24)
+	for _, p := range ps {
+		assert.True(t, p.Estimated)
+		assert.InDelta(t, 7.4602, p.EstimatedRate, 1e-9)
+	}
+	noon := ps[12]
+	assert.True(t, noon.ValidFrom.Equal(from.Add(12*time.Hour)))
+	raw := 48.8 * 7.4602 / 1000
+	// the grid tariff at noon, the electricity tax and the system tariff
+	tariffs := 0.25 + 0.72 + 0.051
+	assert.InDelta(t, raw, noon.RawPrice, 1e-9)
+	assert.InDelta(t, tariffs, noon.TaxesSubTotal, 1e-9)
+	assert.InDelta(t, (raw+tariffs)*1.25, noon.TotalIncVAT, 1e-9)
+}
+
+func TestPrices_PartialDay(t *testing.T) {
+	up := fakeupstream.New(t)
+	now := time.Date(2025, 10, 6, 10, 0, 0, 0, entities.Location)
+	s := fakeService(up, now)
+	ctx := context.Background()
+	ps, err := s.Prices(ctx, now, now.Add(24*time.Hour), fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	require.NoError(t, err)
+	// tomorrow's prices aren't published yet, so there are only prices until
+	// midnight, for 15 minutes each
+	require.Len(t, ps, 56)
+	assert.True(t, ps[55].ValidTo.Equal(time.Date(2025, 10, 7, 0, 0, 0, 0, entities.Location)))
+	for _, p := range ps {
+		assert.Equal(t, 15*time.Minute, p.ValidTo.Sub(p.ValidFrom))
+		assert.False(t, p.Estimated)
+	}
+	raw := 74.2 * 7.4640 / 1000
+	assert.InDelta(t, raw, ps[0].RawPrice, 1e-9)
+	assert.InDelta(t, (raw+0.25+0.72+0.051)*1.25, ps[0].TotalIncVAT, 1e-9)
+
+	// prices and tariffs are kept in memory
+	_, err = s.Prices(ctx, now.Add(time.Hour), now.Add(2*time.Hour), fakeupstream.Config{Key: fakeupstream.Token, PriceArea: "DK1"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, up.Requests("/DayAheadPrices"))
+	assert.Equal(t, 1, up.Requests("/token"))
+	assert.Equal(t, 1, up.Requests("/meteringpoints/meteringpoint/getcharges"))
+}
+
+func TestPrices_AuthFailure(t *testing.T) {
+	up := fakeupstream.New(t)
+	now := time.Date(2025, 10, 6, 10, 0, 0, 0, entities.Location)
+	s := fakeService(up, now)
+	ctx := context.Background()
+
+	_, err := s.Prices(ctx, now, now.Add(time.Hour), fakeupstream.Config{Key: "not-a-token", PriceArea: "DK1"}, false)
+	assert.Error(t, err)
+
+	// unless missing tariffs are ignored
+	ps, err := s.Prices(ctx, now, now.Add(time.Hour), fakeupstream.Config{Key: "not-a-token", PriceArea: "DK1"}, true)
+	require.NoError(t, err)
+	require.Len(t, ps, 4)
+	assert.Zero(t, ps[0].TaxesSubTotal)
+	assert.Zero(t, up.Requests("/meteringpoints/meteringpoint/getcharges"))
+}
+
+func TestArea(t *testing.T) {
+	up := fakeupstream.New(t)
+	ctx := context.Background()
+
+	// failing to detect the area isn't a guess at one
+	s := fakeService(up, time.Now())
+	_, err := s.Area(ctx, fakeupstream.Config{Key: "not-a-token"})
+	assert.ErrorIs(t, err, ErrNoArea)
+
+	a, err := s.Area(ctx, fakeupstream.Config{Key: fakeupstream.Token})
+	require.NoError(t, err)
+	assert.Equal(t, energidataservice.AreaDKWest, a)
+}
@@ -0,0 +1,117 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/interfaces"
+	"github.com/adamhassel/power/repos/energidataservice"
+)
+
+var ErrNoWindow = errors.New("no window of the requested length with known prices")
+
+// Window is a contiguous period and the price of using power in it. Average is
+// per kWh, and Total is the price of drawing 1 kW for the whole window. Both
+// include VAT.
+type Window struct {
+	From    time.Time            `json:"from"`
+	To      time.Time            `json:"to"`
+	Average float64              `json:"average_inc_vat"`
+	Total   float64              `json:"total_inc_vat"`
+	Prices  []entities.FullPrice `json:"prices"`
+}
+
+// CheapestWindow finds the cheapest contiguous window of length d, starting no
+// earlier than `earliest` and ending no later than `deadline`. A zero deadline
+// means as late as there are prices for. Windows start at the beginning of a
+// price period, or at `earliest`, and may end partway through one.
+func (fp FullPrices) CheapestWindow(d time.Duration, earliest, deadline time.Time) (Window, error) {
+	if d <= 0 {
+		return Window{}, errors.New("window length must be positive")
+	}
+	var best Window
+	var found bool
+	for i, p := range fp.Contents {
+		start := p.ValidFrom
+		if start.Before(earliest) {
+			if !p.ValidTo.After(earliest) {
+				continue
+			}
+			start = earliest
+		}
+		end := start.Add(d)
+		if !deadline.IsZero() && end.After(deadline) {
+			break
+		}
+		w, ok := window(fp.Contents[i:], start, end)
+		if ok && (!found || w.Total < best.Total) {
+			best, found = w, true
+		}
+	}
+	if !found {
+		return Window{}, ErrNoWindow
+	}
+	return best, nil
+}
+
+// window returns the window from - to, made up of the prices in ps, which must
+// start with the one containing from. ok is false if ps has holes in that period.
+func window(ps []entities.FullPrice, from, to time.Time) (w Window, ok bool) {
+	w.From, w.To = from, to
+	covered := from
+	for _, p := range ps {
+		if !covered.Before(to) {
+			break
+		}
+		if p.ValidFrom.After(covered) || !p.ValidTo.After(covered) {
+			return Window{}, false
+		}
+		end := p.ValidTo
+		if end.After(to) {
+			end = to
+		}
+		w.Total += p.TotalIncVAT * end.Sub(covered).Hours()
+		w.Prices = append(w.Prices, p)
+		covered = end
+	}
+	if covered.Before(to) {
+		return Window{}, false
+	}
+	w.Average = w.Total / to.Sub(from).Hours()
+	return w, true
+}
+
+// CheapestWindow fetches prices in the area a, and finds the cheapest window of
+// length d between `earliest` and `deadline`. See FullPrices.CheapestWindow.
+func (s *PriceService) CheapestWindow(ctx context.Context, d time.Duration, earliest, deadline time.Time, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) (Window, error) {
+	fp, err := s.pricesAround(ctx, earliest, deadline, a, c, ignoreMissingTariffs)
+	if err != nil {
+		return Window{}, err
+	}
+	return fp.CheapestWindow(d, earliest, deadline)
+}
+
+// CheapestWindow calls CheapestWindow on the default PriceService
+func CheapestWindow(ctx context.Context, d time.Duration, earliest, deadline time.Time, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) (Window, error) {
+	return Default.CheapestWindow(ctx, d, earliest, deadline, a, c, ignoreMissingTariffs)
+}
+
+// pricesAround returns prices from the start of the hour containing from, until
+// `to`, or as far ahead as prices are available if `to` is zero.
+func (s *PriceService) pricesAround(ctx context.Context, from, to time.Time, a energidataservice.Area, c interfaces.Configurator, ignoreMissingTariffs bool) (FullPrices, error) {
+	from = from.Truncate(time.Hour)
+	if to.IsZero() {
+		to = s.clock.Now().Truncate(24 * time.Hour).Add(48 * time.Hour)
+	}
+	ps, err := s.PricesInArea(ctx, from, to, a, c, ignoreMissingTariffs)
+	if err != nil {
+		return FullPrices{}, err
+	}
+	rv := FullPrices{Contents: ps}
+	if len(ps) > 0 {
+		rv.From, rv.To = ps[0].ValidFrom, ps[len(ps)-1].ValidTo
+	}
+	return rv, nil
+}
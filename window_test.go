@@ -0,0 +1,68 @@
+package power
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adamhassel/power/entities"
+	"github.com/adamhassel/power/internal/pricetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPrices returns the prices of pricetest.Prices as FullPrices
+func testPrices(start time.Time, d time.Duration, totals ...float64) FullPrices {
+	return FullPrices{Contents: pricetest.Prices(start, d, totals...), From: start, To: start.Add(time.Duration(len(totals)) * d)}
+}
+
+func TestFullPrices_CheapestWindow(t *testing.T) {
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, entities.Location)
+	fp := testPrices(start, time.Hour, 3, 2, 1, 1, 4, 1, 0.5, 5)
+
+	tests := []struct {
+		name     string
+		d        time.Duration
+		earliest time.Time
+		deadline time.Time
+		from     time.Time
+		average  float64
+		total    float64
+	}{
+		{name: "three hours", d: 3 * time.Hour, earliest: start, from: start.Add(time.Hour), average: 4.0 / 3, total: 4},
+		{name: "two hours", d: 2 * time.Hour, earliest: start, from: start.Add(5 * time.Hour), average: 0.75, total: 1.5},
+		{name: "before deadline", d: 2 * time.Hour, earliest: start, deadline: start.Add(5 * time.Hour), from: start.Add(2 * time.Hour), average: 1, total: 2},
+		{name: "partial hour", d: 90 * time.Minute, earliest: start, from: start.Add(5 * time.Hour), average: 1.25 / 1.5, total: 1.25},
+		{name: "start within an hour", d: time.Hour, earliest: start.Add(90 * time.Minute), deadline: start.Add(3 * time.Hour), from: start.Add(2 * time.Hour), average: 1, total: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := fp.CheapestWindow(tt.d, tt.earliest, tt.deadline)
+			require.NoError(t, err)
+			assert.Equal(t, tt.from, w.From)
+			assert.Equal(t, tt.from.Add(tt.d), w.To)
+			assert.InDelta(t, tt.average, w.Average, 1e-9)
+			assert.InDelta(t, tt.total, w.Total, 1e-9)
+		})
+	}
+
+	_, err := fp.CheapestWindow(10*time.Hour, start, time.Time{})
+	assert.ErrorIs(t, err, ErrNoWindow)
+}
+
+func TestFullPrices_CheapestWindowQuarterHours(t *testing.T) {
+	start := time.Date(2025, 10, 2, 0, 0, 0, 0, entities.Location)
+	fp := testPrices(start, 15*time.Minute, 4, 4, 1, 1, 1, 1, 2, 4)
+
+	w, err := fp.CheapestWindow(time.Hour, start, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(30*time.Minute), w.From)
+	assert.Len(t, w.Prices, 4)
+	assert.InDelta(t, 1, w.Average, 1e-9)
+
+	// a hole in the prices breaks up windows
+	fp.Contents = append(fp.Contents[:3], fp.Contents[4:]...)
+	w, err = fp.CheapestWindow(time.Hour, start, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(60*time.Minute), w.From)
+	assert.InDelta(t, 2, w.Average, 1e-9)
+}